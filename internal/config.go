@@ -1,18 +1,32 @@
 package internal
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
 
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
 	log "github.com/sirupsen/logrus"
 )
 
 var defaultConfig = Config{
-	Port:            8000,
-	IntervalSeconds: 10,
+	Port:                       8000,
+	IntervalSeconds:            10,
+	DedupWindowSeconds:         300,
+	DedupMaxFrameIDs:           10000,
+	MaxBodyBytes:               5 * 1024 * 1024,
+	MaxDecompressedBodyBytes:   20 * 1024 * 1024,
+	MaxConcurrentRequests:      100,
+	LateLineToleranceSeconds:   300,
+	FutureLineToleranceSeconds: 60,
+	HistogramPercentiles:       registry.DefaultPercentiles,
+	DynoSizeCPUs:               defaultDynoSizeCPUs,
+	MaxRouterPaths:             100,
 }
 
 type Config struct {
@@ -25,6 +39,89 @@ type Config struct {
 	DimensionPairsToExclude map[string]string
 	Debug                   bool
 	SendInternalMetrics     bool
+	// Logplex-Frame-Id values are remembered for this long to discard
+	// retried drain requests. Deduplication is disabled when set to 0.
+	DedupWindowSeconds int
+	// Maximum number of frame IDs remembered per drain token
+	DedupMaxFrameIDs int
+	// Drain requests with larger bodies are rejected with a 413
+	MaxBodyBytes int64
+	// Compressed drain requests are rejected with a 413 when their body
+	// is larger than this once decompressed
+	MaxDecompressedBodyBytes int64
+	// Drain requests are rejected with a 503 when there are already this
+	// many requests being processed. No limit is applied when set to 0.
+	MaxConcurrentRequests int
+	// Credentials expected in the drain URL, i.e.
+	// https://user:password@<SFX_COLLECTOR_APP_NAME>.herokuapp.com
+	DrainUsername string
+	DrainPassword string
+	// Logplex-Drain-Token values that are allowed to send logs. All drain
+	// tokens are allowed when empty.
+	DrainTokens map[string]bool
+	// Dimensions, including app_name, to add to datapoints from drains
+	// with a given Logplex-Drain-Token
+	DrainTokenMapping map[string]map[string]string
+	// Dimensions, including app_name, to add to datapoints from log lines
+	// with a given syslog hostname or appname
+	SyslogNameMapping map[string]map[string]string
+	// Names of RFC 5424 structured data parameters to add as dimensions
+	StructuredDataDimensions []string
+	// Ports on which to accept syslog over TCP and TLS connections
+	SyslogTCPPorts []SyslogPort
+	SyslogTLSPorts []SyslogPort
+	SyslogUDPPorts []SyslogPort
+	// Apps that syslog datagrams are from, by source address
+	SyslogUDPSources []SyslogSource
+	// PEM encoded certificate and private key for syslog over TLS
+	SyslogTLSCert string
+	SyslogTLSKey  string
+	// Whether gauges and counters are reported at the time of the log line
+	// they come from, bucketed to the reporting interval, instead of the
+	// time they are sent at
+	UseLogTimestamps bool
+	// Lines with timestamps further in the past or the future than this
+	// are dropped when UseLogTimestamps is set
+	LateLineToleranceSeconds   int
+	FutureLineToleranceSeconds int
+	// Percentiles, between 0 and 100, reported for histograms
+	HistogramPercentiles []float64
+	// Plans of Heroku add-ons, by add-on name, to report as a dimension of
+	// add-on metrics, since they are not included in the logs
+	AddonPlans map[string]string
+	// Rules deriving metrics from log lines matching regular expressions
+	LogRules []LogRule
+	// Fields of JSON log messages to report as metrics and dimensions
+	JSONMapping *JSONMapping
+	// Number of CPUs of dynos by lowercase size, i.e. "performance-m", used
+	// to derive the load average per CPU of dynos
+	DynoSizeCPUs map[string]float64
+	// Size of dynos of process types that were not scaled since the
+	// collector started, in which case their size is unknown
+	DefaultDynoSize string
+	// Whether to add the normalized path of requests as a dimension of
+	// router metrics
+	RouterPathDimension bool
+	// Templates applied to the paths of requests before they are added as a
+	// dimension
+	RouterPathTemplates []PathTemplate
+	// Paths of requests to an app past this many distinct paths are reported
+	// as "other". No limit is applied when set to 0.
+	MaxRouterPaths int
+}
+
+// SyslogSource is a network from which syslog datagrams are received,
+// along with the app that they are from
+type SyslogSource struct {
+	Network *net.IPNet
+	AppName string
+}
+
+// SyslogPort is a port on which to accept syslog connections, along with
+// the app that messages received on it are from, if known
+type SyslogPort struct {
+	Port    int
+	AppName string
 }
 
 func ConfigFromEnv() *Config {
@@ -57,6 +154,129 @@ func ConfigFromEnv() *Config {
 		}
 	}
 
+	var err error
+
+	c.DedupWindowSeconds, err = evaluateIntEnvVariable(os.Getenv("SFX_DEDUP_WINDOW_SECONDS"), c.DedupWindowSeconds)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_DEDUP_WINDOW_SECONDS: %v", err)
+	}
+
+	c.DedupMaxFrameIDs, err = evaluateIntEnvVariable(os.Getenv("SFX_DEDUP_MAX_FRAME_IDS"), c.DedupMaxFrameIDs)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_DEDUP_MAX_FRAME_IDS: %v", err)
+	}
+
+	c.MaxBodyBytes, err = evaluateSizeEnvVariable(os.Getenv("SFX_MAX_BODY_SIZE"), c.MaxBodyBytes)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_MAX_BODY_SIZE: %v", err)
+	}
+
+	c.MaxDecompressedBodyBytes, err = evaluateSizeEnvVariable(os.Getenv("SFX_MAX_DECOMPRESSED_BODY_SIZE"), c.MaxDecompressedBodyBytes)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_MAX_DECOMPRESSED_BODY_SIZE: %v", err)
+	}
+
+	c.MaxConcurrentRequests, err = evaluateIntEnvVariable(os.Getenv("SFX_MAX_CONCURRENT_REQUESTS"), c.MaxConcurrentRequests)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_MAX_CONCURRENT_REQUESTS: %v", err)
+	}
+
+	c.DrainUsername = os.Getenv("SFX_DRAIN_USERNAME")
+	c.DrainPassword = os.Getenv("SFX_DRAIN_PASSWORD")
+	c.DrainTokens = getDrainTokens(os.Getenv("SFX_DRAIN_TOKENS"))
+
+	c.DrainTokenMapping, err = getDimensionMapping(os.Getenv("SFX_DRAIN_TOKEN_MAPPING"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_DRAIN_TOKEN_MAPPING: %v", err)
+	}
+
+	c.SyslogNameMapping, err = getDimensionMapping(os.Getenv("SFX_SYSLOG_NAME_MAPPING"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_SYSLOG_NAME_MAPPING: %v", err)
+	}
+
+	c.StructuredDataDimensions = getStructuredDataDimensions(os.Getenv("SFX_STRUCTURED_DATA_DIMENSIONS"))
+
+	c.SyslogTCPPorts, err = getSyslogPorts(os.Getenv("SFX_SYSLOG_TCP_PORTS"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_SYSLOG_TCP_PORTS: %v", err)
+	}
+
+	c.SyslogTLSPorts, err = getSyslogPorts(os.Getenv("SFX_SYSLOG_TLS_PORTS"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_SYSLOG_TLS_PORTS: %v", err)
+	}
+
+	c.SyslogUDPPorts, err = getSyslogPorts(os.Getenv("SFX_SYSLOG_UDP_PORTS"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_SYSLOG_UDP_PORTS: %v", err)
+	}
+
+	c.SyslogUDPSources, err = getSyslogSources(os.Getenv("SFX_SYSLOG_UDP_SOURCES"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_SYSLOG_UDP_SOURCES: %v", err)
+	}
+
+	c.SyslogTLSCert = os.Getenv("SFX_SYSLOG_TLS_CERT")
+	c.SyslogTLSKey = os.Getenv("SFX_SYSLOG_TLS_KEY")
+
+	c.UseLogTimestamps, err = evaluateBoolEnvVariable(os.Getenv("SFX_USE_LOG_TIMESTAMPS"), false)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_USE_LOG_TIMESTAMPS: %v", err)
+	}
+
+	c.LateLineToleranceSeconds, err = evaluateIntEnvVariable(os.Getenv("SFX_LATE_LINE_TOLERANCE_SECONDS"), c.LateLineToleranceSeconds)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_LATE_LINE_TOLERANCE_SECONDS: %v", err)
+	}
+
+	c.FutureLineToleranceSeconds, err = evaluateIntEnvVariable(os.Getenv("SFX_FUTURE_LINE_TOLERANCE_SECONDS"), c.FutureLineToleranceSeconds)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_FUTURE_LINE_TOLERANCE_SECONDS: %v", err)
+	}
+
+	c.HistogramPercentiles, err = getPercentiles(os.Getenv("SFX_HISTOGRAM_PERCENTILES"), c.HistogramPercentiles)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_HISTOGRAM_PERCENTILES: %v", err)
+	}
+
+	c.AddonPlans, err = getAddonPlans(os.Getenv("SFX_ADDON_PLANS"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_ADDON_PLANS: %v", err)
+	}
+
+	c.LogRules, err = getLogRules(os.Getenv("SFX_LOG_RULES"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_LOG_RULES: %v", err)
+	}
+
+	c.JSONMapping, err = getJSONMapping(os.Getenv("SFX_JSON_MAPPING"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_JSON_MAPPING: %v", err)
+	}
+
+	c.DynoSizeCPUs, err = getDynoSizeCPUs(os.Getenv("SFX_DYNO_SIZE_CPUS"), c.DynoSizeCPUs)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_DYNO_SIZE_CPUS: %v", err)
+	}
+
+	c.DefaultDynoSize = os.Getenv("SFX_DEFAULT_DYNO_SIZE")
+
+	c.RouterPathDimension, err = evaluateBoolEnvVariable(os.Getenv("SFX_ROUTER_PATH_DIMENSION"), false)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_ROUTER_PATH_DIMENSION: %v", err)
+	}
+
+	c.RouterPathTemplates, err = getPathTemplates(os.Getenv("SFX_ROUTER_PATH_TEMPLATES"))
+	if err != nil {
+		log.Errorf("Failed to parse SFX_ROUTER_PATH_TEMPLATES: %v", err)
+	}
+
+	c.MaxRouterPaths, err = evaluateIntEnvVariable(os.Getenv("SFX_MAX_ROUTER_PATHS"), c.MaxRouterPaths)
+	if err != nil {
+		log.Errorf("Failed to parse SFX_MAX_ROUTER_PATHS: %v", err)
+	}
+
 	c.MetricsToExclude = getMetricsToExclude(os.Getenv("SFX_METRICS_TO_EXCLUDE"))
 	c.DimensionPairsToExclude = getDimensionPairsToExclude(os.Getenv("SFX_DIMENSION_PAIRS_TO_EXCLUDE"))
 
@@ -72,9 +292,49 @@ func (c *Config) Validate() error {
 		return errors.New("at least one of SFX_INGEST_URL or SFX_REALM should be set")
 	}
 
+	if (c.DrainUsername == "") != (c.DrainPassword == "") {
+		return errors.New("SFX_DRAIN_USERNAME and SFX_DRAIN_PASSWORD should be set together")
+	}
+
+	// Syslog connections are not authenticated, so lines received on ports
+	// that are not bound to an app must come from allowed drain tokens
+	if len(c.DrainTokens) == 0 {
+		for _, p := range append(append([]SyslogPort{}, c.SyslogTCPPorts...), c.SyslogTLSPorts...) {
+			if p.AppName == "" {
+				return fmt.Errorf("syslog port %d should have an app name when SFX_DRAIN_TOKENS is not set", p.Port)
+			}
+		}
+
+		// Datagrams can also be bound to an app by their source address
+		for _, p := range c.SyslogUDPPorts {
+			if p.AppName == "" && len(c.SyslogUDPSources) == 0 {
+				return fmt.Errorf("syslog UDP port %d should have an app name when neither SFX_DRAIN_TOKENS nor SFX_SYSLOG_UDP_SOURCES is set", p.Port)
+			}
+		}
+	}
+
+	if len(c.SyslogTLSPorts) > 0 {
+		if _, err := c.SyslogTLSConfig(); err != nil {
+			return fmt.Errorf("invalid SFX_SYSLOG_TLS_CERT or SFX_SYSLOG_TLS_KEY: %v", err)
+		}
+	}
+
 	return nil
 }
 
+// SyslogTLSConfig returns the TLS configuration for syslog over TLS listeners
+func (c *Config) SyslogTLSConfig() (*tls.Config, error) {
+	cert, err := tls.X509KeyPair([]byte(c.SyslogTLSCert), []byte(c.SyslogTLSKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
 func getMetricsToExclude(metricsToExcludeEnv string) map[string]bool {
 	if metricsToExcludeEnv == "" {
 		return nil
@@ -86,6 +346,209 @@ func getMetricsToExclude(metricsToExcludeEnv string) map[string]bool {
 	return out
 }
 
+func getDrainTokens(drainTokensEnv string) map[string]bool {
+	if drainTokensEnv == "" {
+		return nil
+	}
+
+	return makeSetOfStringsFromArray(splitList(drainTokensEnv))
+}
+
+// Mappings are JSON objects of the following form
+// {"d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f": {"app_name": "my-app", "team": "payments"}}
+func getDimensionMapping(mappingEnv string) (map[string]map[string]string, error) {
+	if mappingEnv == "" {
+		return nil, nil
+	}
+
+	var out map[string]map[string]string
+	if err := json.Unmarshal([]byte(mappingEnv), &out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Plans are JSON objects of the following form
+// {"postgresql-curved-12345": "standard-0", "redis-shallow-12345": "premium-0"}
+func getAddonPlans(plansEnv string) (map[string]string, error) {
+	if plansEnv == "" {
+		return nil, nil
+	}
+
+	var out map[string]string
+	if err := json.Unmarshal([]byte(plansEnv), &out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Rules are JSON arrays of the following form
+// [{"metric": "checkouts", "type": "counter", "pattern": "checkout completed plan=(?P<plan>\\w+)"}]
+func getLogRules(rulesEnv string) ([]LogRule, error) {
+	if rulesEnv == "" {
+		return nil, nil
+	}
+
+	var out []LogRule
+	if err := json.Unmarshal([]byte(rulesEnv), &out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Mappings are JSON objects of the following form
+// {"metrics": {"db.duration": "histogram"}, "dimensions": {"request.route": "route"}}
+func getJSONMapping(mappingEnv string) (*JSONMapping, error) {
+	if mappingEnv == "" {
+		return nil, nil
+	}
+
+	var out JSONMapping
+	if err := json.Unmarshal([]byte(mappingEnv), &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// CPUs are JSON objects of the following form, which are merged with the
+// default number of CPUs of dyno sizes
+// {"performance-m": 2, "private-l": 8}
+func getDynoSizeCPUs(cpusEnv string, defaultVal map[string]float64) (map[string]float64, error) {
+	if cpusEnv == "" {
+		return defaultVal, nil
+	}
+
+	var cpus map[string]float64
+	if err := json.Unmarshal([]byte(cpusEnv), &cpus); err != nil {
+		return defaultVal, err
+	}
+
+	out := make(map[string]float64, len(defaultVal)+len(cpus))
+	for size, n := range defaultVal {
+		out[size] = n
+	}
+
+	for size, n := range cpus {
+		out[strings.ToLower(size)] = n
+	}
+
+	return out, nil
+}
+
+// Templates are JSON arrays of the following form
+// [{"pattern": "^/users/[^/]+/avatar$", "template": "/users/:name/avatar"}]
+func getPathTemplates(templatesEnv string) ([]PathTemplate, error) {
+	if templatesEnv == "" {
+		return nil, nil
+	}
+
+	var out []PathTemplate
+	if err := json.Unmarshal([]byte(templatesEnv), &out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func getStructuredDataDimensions(namesEnv string) []string {
+	if namesEnv == "" {
+		return nil
+	}
+
+	return splitList(namesEnv)
+}
+
+// Ports are of the following form "6514=my-app,6515", where the app name is
+// optional
+func getSyslogPorts(portsEnv string) ([]SyslogPort, error) {
+	if portsEnv == "" {
+		return nil, nil
+	}
+
+	var out []SyslogPort
+
+	for _, entry := range strings.Split(portsEnv, ",") {
+		splitEntry := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+
+		port, err := strconv.ParseInt(splitEntry[0], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		syslogPort := SyslogPort{Port: int(port)}
+		if len(splitEntry) == 2 {
+			syslogPort.AppName = splitEntry[1]
+		}
+
+		out = append(out, syslogPort)
+	}
+
+	return out, nil
+}
+
+// Sources are of the following form "10.0.0.5=my-app,10.0.1.0/24=other-app",
+// where the address can either be an IP address or a CIDR
+func getSyslogSources(sourcesEnv string) ([]SyslogSource, error) {
+	if sourcesEnv == "" {
+		return nil, nil
+	}
+
+	var out []SyslogSource
+
+	for _, entry := range strings.Split(sourcesEnv, ",") {
+		splitEntry := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(splitEntry) != 2 || splitEntry[1] == "" {
+			return nil, fmt.Errorf("expected address=app_name, got %q", entry)
+		}
+
+		address := splitEntry[0]
+		if !strings.Contains(address, "/") {
+			if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+				address += "/128"
+			} else {
+				address += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, SyslogSource{Network: network, AppName: splitEntry[1]})
+	}
+
+	return out, nil
+}
+
+// Percentiles are of the following form "50,90,99.9"
+func getPercentiles(percentilesEnv string, defaultVal []float64) ([]float64, error) {
+	if percentilesEnv == "" {
+		return defaultVal, nil
+	}
+
+	var out []float64
+
+	for _, item := range splitList(percentilesEnv) {
+		p, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return defaultVal, err
+		}
+
+		if p <= 0 || p > 100 {
+			return defaultVal, fmt.Errorf("percentile %q is not between 0 and 100", item)
+		}
+
+		out = append(out, p)
+	}
+
+	return out, nil
+}
+
 func getDimensionPairsToExclude(dimensionPairsEnv string) map[string]string {
 	if dimensionPairsEnv == "" {
 		return nil
@@ -36,3 +36,192 @@ func TestGetDimensionPairsToExclude(t *testing.T) {
 		t.Errorf("Expected: %v, Actual: %v", expected, actual)
 	}
 }
+
+func TestGetDrainTokens(t *testing.T) {
+	expected := map[string]bool{
+		"d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f": true,
+		"d.2c3f5dca-c3d7-4b21-9d24-1e3bd7f3a1d9": true,
+	}
+
+	actual := getDrainTokens("d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f, d.2c3f5dca-c3d7-4b21-9d24-1e3bd7f3a1d9,")
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v", expected, actual)
+	}
+}
+
+func TestGetDimensionMapping(t *testing.T) {
+	expected := map[string]map[string]string{
+		"d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f": {"app_name": "my-app", "team": "payments"},
+	}
+
+	actual, err := getDimensionMapping(`{"d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f": {"app_name": "my-app", "team": "payments"}}`)
+
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v (%v)", expected, actual, err)
+	}
+
+	if _, err := getDimensionMapping(`{"d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f": "my-app"}`); err == nil {
+		t.Errorf("Expected invalid mapping to fail")
+	}
+}
+
+func TestGetSyslogPorts(t *testing.T) {
+	expected := []SyslogPort{
+		{Port: 6514, AppName: "my-app"},
+		{Port: 6515},
+	}
+
+	actual, err := getSyslogPorts("6514=my-app, 6515")
+
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v (%v)", expected, actual, err)
+	}
+
+	if _, err := getSyslogPorts("my-app=6514"); err == nil {
+		t.Errorf("Expected invalid port to fail")
+	}
+}
+
+func TestGetSyslogSources(t *testing.T) {
+	sources, err := getSyslogSources("10.0.0.5=my-app,10.0.1.0/24=other-app,::1=local-app")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{"10.0.0.5/32=my-app", "10.0.1.0/24=other-app", "::1/128=local-app"}
+
+	var actual []string
+	for _, source := range sources {
+		actual = append(actual, source.Network.String()+"="+source.AppName)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v", expected, actual)
+	}
+
+	if _, err := getSyslogSources("10.0.0.5"); err == nil {
+		t.Errorf("Expected source without an app name to fail")
+	}
+}
+
+func TestGetPercentiles(t *testing.T) {
+	expected := []float64{50, 99, 99.9}
+
+	actual, err := getPercentiles("50, 99,99.9", nil)
+
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v (%v)", expected, actual, err)
+	}
+
+	for _, invalid := range []string{"p99", "0", "101"} {
+		if _, err := getPercentiles(invalid, nil); err == nil {
+			t.Errorf("Expected invalid percentiles %q to fail", invalid)
+		}
+	}
+}
+
+func TestGetAddonPlans(t *testing.T) {
+	expected := map[string]string{"postgresql-curved-12345": "standard-0"}
+
+	actual, err := getAddonPlans(`{"postgresql-curved-12345": "standard-0"}`)
+
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v (%v)", expected, actual, err)
+	}
+
+	if _, err := getAddonPlans(`["standard-0"]`); err == nil {
+		t.Errorf("Expected invalid plans to fail")
+	}
+}
+
+func TestGetLogRules(t *testing.T) {
+	expected := []LogRule{
+		{Metric: "checkouts", Type: "counter", Pattern: `checkout completed plan=(?P<plan>\w+)`, Appname: "app"},
+	}
+
+	actual, err := getLogRules(`[{"metric": "checkouts", "type": "counter", "pattern": "checkout completed plan=(?P<plan>\\w+)", "appname": "app"}]`)
+
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v (%v)", expected, actual, err)
+	}
+
+	if _, err := getLogRules(`{"metric": "checkouts"}`); err == nil {
+		t.Errorf("Expected invalid rules to fail")
+	}
+}
+
+func TestGetJSONMapping(t *testing.T) {
+	expected := &JSONMapping{
+		Metrics:    map[string]string{"db.duration": "histogram"},
+		Dimensions: map[string]string{"request.route": "route"},
+	}
+
+	actual, err := getJSONMapping(`{"metrics": {"db.duration": "histogram"}, "dimensions": {"request.route": "route"}}`)
+
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v (%v)", expected, actual, err)
+	}
+
+	if _, err := getJSONMapping(`["db.duration"]`); err == nil {
+		t.Errorf("Expected invalid mapping to fail")
+	}
+}
+
+func TestGetDynoSizeCPUs(t *testing.T) {
+	actual, err := getDynoSizeCPUs(`{"Private-L": 8, "performance-m": 4}`, defaultDynoSizeCPUs)
+
+	if err != nil || actual["private-l"] != 8 || actual["performance-m"] != 4 || actual["standard-2x"] != 2 {
+		t.Errorf("Unexpected dyno size CPUs: %v (%v)", actual, err)
+	}
+
+	if defaultDynoSizeCPUs["performance-m"] != 2 {
+		t.Errorf("Default dyno size CPUs should not be modified")
+	}
+}
+
+func TestGetPathTemplates(t *testing.T) {
+	expected := []PathTemplate{{Pattern: "^/users/[^/]+/avatar$", Template: "/users/:name/avatar"}}
+
+	actual, err := getPathTemplates(`[{"pattern": "^/users/[^/]+/avatar$", "template": "/users/:name/avatar"}]`)
+
+	if err != nil || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected: %v, Actual: %v (%v)", expected, actual, err)
+	}
+}
+
+func TestValidateSyslogPorts(t *testing.T) {
+	conf := defaultConfig
+	conf.AccessToken = "token"
+	conf.Realm = "us0"
+	conf.SyslogTCPPorts = []SyslogPort{{Port: 6514}}
+
+	if err := conf.Validate(); err == nil {
+		t.Errorf("Expected syslog port without an app name to be rejected without drain tokens")
+	}
+
+	conf.DrainTokens = getDrainTokens("d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f")
+
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	conf.DrainTokens = nil
+	conf.SyslogTCPPorts = []SyslogPort{{Port: 6514, AppName: "my-app"}}
+
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	conf.SyslogUDPPorts = []SyslogPort{{Port: 514}}
+
+	if err := conf.Validate(); err == nil {
+		t.Errorf("Expected syslog UDP port without an app name to be rejected without sources")
+	}
+
+	conf.SyslogUDPSources, _ = getSyslogSources("10.0.0.0/8=my-app")
+
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// frameDeduplicator keeps track of the Logplex-Frame-Id values seen in a
+// recent time window, per drain token. Logplex retries a drain request with
+// the same frame ID when it does not get a timely response, and applying
+// the same frame twice would double count counter and cumulative metrics.
+type frameDeduplicator struct {
+	sync.Mutex
+
+	window           time.Duration
+	maxIDsPerToken   int
+	frameIDsByTokens map[string]*frameIDSet
+
+	// This is the source of truth for the current time and exists to make unit
+	// testing easier
+	currentTime func() time.Time
+}
+
+// A set of frame IDs along with a list of the same IDs, sorted by the time
+// at which they were seen, to quickly find expired IDs
+type frameIDSet struct {
+	seenList list.List
+	seen     map[string]*list.Element
+}
+
+type seenFrame struct {
+	ts time.Time
+	id string
+}
+
+func newFrameDeduplicator(window time.Duration, maxIDsPerToken int) *frameDeduplicator {
+	return &frameDeduplicator{
+		window:           window,
+		maxIDsPerToken:   maxIDsPerToken,
+		frameIDsByTokens: map[string]*frameIDSet{},
+		currentTime:      time.Now,
+	}
+}
+
+// isDuplicate records the frame ID for the given drain token and returns
+// true if it was already seen within the time window
+func (d *frameDeduplicator) isDuplicate(token, frameID string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.currentTime()
+	d.purgeExpired(now)
+
+	set := d.frameIDsByTokens[token]
+	if set == nil {
+		set = &frameIDSet{seen: map[string]*list.Element{}}
+		d.frameIDsByTokens[token] = set
+	}
+
+	if _, ok := set.seen[frameID]; ok {
+		return true
+	}
+
+	set.seen[frameID] = set.seenList.PushFront(&seenFrame{ts: now, id: frameID})
+
+	// Drop the oldest IDs when the set is full
+	for d.maxIDsPerToken > 0 && set.seenList.Len() > d.maxIDsPerToken {
+		set.remove(set.seenList.Back())
+	}
+
+	return false
+}
+
+// The lock should be held when calling this method.
+func (d *frameDeduplicator) purgeExpired(now time.Time) {
+	for token, set := range d.frameIDsByTokens {
+		// The list is sorted with the oldest IDs at the back
+		elm := set.seenList.Back()
+		for elm != nil && now.Sub(elm.Value.(*seenFrame).ts) > d.window {
+			prev := elm.Prev()
+			set.remove(elm)
+			elm = prev
+		}
+
+		if set.seenList.Len() == 0 {
+			delete(d.frameIDsByTokens, token)
+		}
+	}
+}
+
+func (s *frameIDSet) remove(elm *list.Element) {
+	delete(s.seen, elm.Value.(*seenFrame).id)
+	s.seenList.Remove(elm)
+}
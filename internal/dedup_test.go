@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameDeduplicator(t *testing.T) {
+	d := newFrameDeduplicator(time.Minute, 3)
+
+	now := time.Unix(100, 0)
+	d.currentTime = func() time.Time { return now }
+
+	require.False(t, d.isDuplicate("d.1", "frame1"))
+	require.True(t, d.isDuplicate("d.1", "frame1"))
+
+	// Frame IDs are tracked per drain token
+	require.False(t, d.isDuplicate("d.2", "frame1"))
+
+	now = now.Add(2 * time.Minute)
+
+	require.False(t, d.isDuplicate("d.1", "frame1"), "Expected frame ID to be expired")
+
+	for i := 0; i < 4; i++ {
+		require.False(t, d.isDuplicate("d.3", fmt.Sprintf("frame%d", i)))
+	}
+
+	require.False(t, d.isDuplicate("d.3", "frame0"), "Expected oldest frame ID to be evicted")
+	require.True(t, d.isDuplicate("d.3", "frame3"))
+}
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+)
+
+const dynoStateChangesMetric = "heroku.dyno_state_changes"
+
+// Dimensions that are specific to a dyno, which are left out of the
+// dimensions of process types
+var dynoSpecificDimensionKeys = makeStringSet("dyno", "dyno_id", "source")
+
+// Number of CPUs of dynos by size, used to derive the load average per CPU.
+// Shared dynos are given their CPU share. For more information, see:
+// https://devcenter.heroku.com/articles/dyno-types
+var defaultDynoSizeCPUs = map[string]float64{
+	"eco":               1,
+	"basic":             1,
+	"standard-1x":       1,
+	"standard-2x":       2,
+	"performance-m":     2,
+	"performance-l":     8,
+	"performance-l-ram": 4,
+	"performance-xl":    8,
+	"performance-2xl":   16,
+}
+
+// Scaling of process types logged by the Heroku API, i.e.
+// "Scaled to web@3:Standard-2X worker@1:Standard-1X by user user@example.com"
+var (
+	scaleFormat            = regexp.MustCompile(`^Scaled to (.+?) by `)
+	processFormationFormat = regexp.MustCompile(`^([\w-]+)@(\d+):(\S+)$`)
+)
+
+// Keeps track of the state of dynos from the state changes logged by Heroku,
+// to report the number of dynos that are up per process type, and of the
+// formation of process types from the scaling logged by the Heroku API
+type dynoTracker struct {
+	sync.Mutex
+
+	processTypes map[string]*processTypeDynos
+	formations   map[string]*processFormation
+	// Formations replaced by a formation of another size, reported as 0 once
+	replaced []*processFormation
+}
+
+// The desired number and size of the dynos of a process type
+type processFormation struct {
+	ProcessType string
+	Quantity    int
+	Size        string
+
+	dims map[string]string
+}
+
+type processTypeDynos struct {
+	dims map[string]string
+	// Names of the dynos that are up, i.e. "web.1"
+	up map[string]bool
+}
+
+func newDynoTracker() *dynoTracker {
+	return &dynoTracker{
+		processTypes: map[string]*processTypeDynos{},
+		formations:   map[string]*processFormation{},
+	}
+}
+
+// Records the new state of a dyno. dims are the dimensions of the line
+// logging the state change, including the process type.
+func (t *dynoTracker) setState(dims map[string]string, dyno string, state string) {
+	processTypeDims := processTypeDimensions(dims)
+	key := dimensionsKey(processTypeDims)
+
+	t.Lock()
+	defer t.Unlock()
+
+	p := t.processTypes[key]
+	if p == nil {
+		p = &processTypeDynos{dims: processTypeDims, up: map[string]bool{}}
+		t.processTypes[key] = p
+	}
+
+	if state == "up" {
+		p.up[dyno] = true
+	} else {
+		delete(p.up, dyno)
+	}
+}
+
+// Records the formation of process types. appDims are the dimensions of the
+// app the process types belong to.
+func (t *dynoTracker) setFormations(appDims map[string]string, formations []*processFormation) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, f := range formations {
+		f.dims = mergeStringMaps(appDims, map[string]string{"process_type": f.ProcessType})
+		key := formationKey(f.dims)
+
+		// The desired count is reported per size, so the count of the
+		// previous size has to be reset when dynos are resized
+		if prev := t.formations[key]; prev != nil && (prev.Size != f.Size || dimensionsKey(prev.dims) != dimensionsKey(f.dims)) {
+			t.replaced = append(t.replaced, &processFormation{ProcessType: prev.ProcessType, Size: prev.Size, dims: prev.dims})
+		}
+
+		t.formations[key] = f
+	}
+}
+
+// Updates the number of dynos that are up and the desired number of dynos
+// per process type in the registry. Process types without any dyno up or
+// scaled down to 0, and the previous sizes of resized process types, are
+// reported one last time, and then forgotten so that the gauges expire.
+func (t *dynoTracker) updateRegistry(reg *registry.MetricRegistry) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, f := range t.replaced {
+		reg.UpdateMetric(&registry.MetricVal{
+			Name:       "heroku.dyno_desired_count",
+			Type:       datapoint.Gauge,
+			Value:      0,
+			Dimensions: map[string]string{"dyno_size": f.Size},
+		}, f.dims)
+	}
+
+	t.replaced = nil
+
+	for key, f := range t.formations {
+		reg.UpdateMetric(&registry.MetricVal{
+			Name:       "heroku.dyno_desired_count",
+			Type:       datapoint.Gauge,
+			Value:      float64(f.Quantity),
+			Dimensions: map[string]string{"dyno_size": f.Size},
+		}, f.dims)
+
+		if f.Quantity == 0 {
+			delete(t.formations, key)
+		}
+	}
+
+	for key, p := range t.processTypes {
+		reg.UpdateMetric(&registry.MetricVal{
+			Name:  "heroku.dynos_up",
+			Type:  datapoint.Gauge,
+			Value: float64(len(p.up)),
+		}, p.dims)
+
+		if len(p.up) == 0 {
+			delete(t.processTypes, key)
+		}
+	}
+}
+
+// Returns the size of the dynos of the process type a line is from, if the
+// process type was scaled since the collector started. dims are the
+// dimensions of the line.
+func (t *dynoTracker) dynoSize(dims map[string]string) string {
+	key := formationKey(dims)
+
+	t.Lock()
+	defer t.Unlock()
+
+	if f := t.formations[key]; f != nil {
+		return f.Size
+	}
+
+	return ""
+}
+
+// Returns the number of CPUs of the dyno a line is from, based on the size of
+// the dynos of its process type, or the default dyno size when unknown.
+// Returns 0 when the size or its number of CPUs is unknown.
+func (l *Listener) dynoCPUs(dims map[string]string) float64 {
+	size := l.dynos.dynoSize(dims)
+	if size == "" {
+		size = l.defaultDynoSize
+	}
+
+	return l.dynoSizeCPUs[strings.ToLower(size)]
+}
+
+// Derives the load average per CPU of a dyno from the 1 minute load average
+// logged by Heroku, given the number of CPUs of the dyno
+func loadAvgPerCPUMetric(metrics []*registry.MetricVal, cpus float64) *registry.MetricVal {
+	if cpus <= 0 {
+		return nil
+	}
+
+	for _, metric := range metrics {
+		if metric.Name == "heroku.load_avg_1m" {
+			return &registry.MetricVal{
+				Name:  "heroku.load_avg_per_cpu",
+				Type:  datapoint.Gauge,
+				Value: metric.Value / cpus,
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the dimensions of the process type of a dyno, from the dimensions
+// of a line logged for the dyno
+func processTypeDimensions(dims map[string]string) map[string]string {
+	out := map[string]string{}
+
+	for k, v := range dims {
+		if !dynoSpecificDimensionKeys[k] {
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
+// Returns the formation of the process types scaled in a line logged by the
+// Heroku API, if any
+func (ll *logLine) herokuFormations() []*processFormation {
+	if ll.Appname != "app" || ll.ProcID != "api" {
+		return nil
+	}
+
+	match := scaleFormat.FindStringSubmatch(ll.Message)
+	if match == nil {
+		return nil
+	}
+
+	var out []*processFormation
+
+	for _, field := range strings.Fields(match[1]) {
+		m := processFormationFormat.FindStringSubmatch(field)
+		if m == nil {
+			continue
+		}
+
+		quantity, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+
+		out = append(out, &processFormation{ProcessType: m[1], Quantity: quantity, Size: m[3]})
+	}
+
+	return out
+}
+
+// Formations are only keyed by app and process type, since lines logged by
+// dynos may have more dimensions than the line logging the scaling
+func formationKey(dims map[string]string) string {
+	return dims["app_name"] + "|" + dims["process_type"]
+}
+
+func dimensionsKey(dims map[string]string) string {
+	keys := make([]string, 0, len(dims))
+	for k := range dims {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + ":" + dims[k] + "|")
+	}
+
+	return b.String()
+}
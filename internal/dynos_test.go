@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynoTracker(t *testing.T) {
+	tracker := newDynoTracker()
+	reg := registry.New(5*time.Minute, nil)
+
+	webDims := func(dyno string) map[string]string {
+		return map[string]string{"app_name": "test", "process_type": "web", "dyno": dyno, "source": dyno}
+	}
+
+	tracker.setState(webDims("web.1"), "web.1", "up")
+	tracker.setState(webDims("web.2"), "web.2", "up")
+	tracker.setState(map[string]string{"app_name": "test", "process_type": "worker", "dyno": "worker.1"}, "worker.1", "up")
+	tracker.setState(webDims("web.2"), "web.2", "crashed")
+
+	tracker.updateRegistry(reg)
+
+	values := map[string]datapoint.Value{}
+	for _, dp := range reg.Datapoints() {
+		require.Equal(t, "heroku.dynos_up", dp.Metric)
+		require.Equal(t, map[string]string{"app_name": "test", "process_type": dp.Dimensions["process_type"]}, dp.Dimensions)
+		values[dp.Dimensions["process_type"]] = dp.Value
+	}
+
+	require.Equal(t, map[string]datapoint.Value{
+		"web":    datapoint.NewFloatValue(1),
+		"worker": datapoint.NewFloatValue(1),
+	}, values)
+
+	// Process types without dynos up are reported as 0 once, then forgotten
+	tracker.setState(map[string]string{"app_name": "test", "process_type": "worker", "dyno": "worker.1"}, "worker.1", "down")
+	tracker.updateRegistry(reg)
+	require.Len(t, tracker.processTypes, 1)
+
+	for _, dp := range reg.Datapoints() {
+		if dp.Dimensions["process_type"] == "worker" {
+			require.Equal(t, datapoint.NewFloatValue(0), dp.Value)
+		}
+	}
+}
+
+func TestHerokuFormations(t *testing.T) {
+	ll, err := detectAndParseLog(`<190>1 2020-01-13T18:27:50+00:00 host app api - Scaled to web@3:Standard-2X worker@0:Standard-1X by user user@example.com`)
+	require.NoError(t, err)
+
+	formations := ll.herokuFormations()
+	require.Equal(t, []*processFormation{
+		{ProcessType: "web", Quantity: 3, Size: "Standard-2X"},
+		{ProcessType: "worker", Quantity: 0, Size: "Standard-1X"},
+	}, formations)
+
+	tracker := newDynoTracker()
+	reg := registry.New(5*time.Minute, nil)
+
+	tracker.setFormations(map[string]string{"app_name": "test"}, formations)
+	tracker.updateRegistry(reg)
+
+	values := map[string]datapoint.Value{}
+	for _, dp := range reg.Datapoints() {
+		require.Equal(t, "heroku.dyno_desired_count", dp.Metric)
+		values[dp.Dimensions["process_type"]+"/"+dp.Dimensions["dyno_size"]] = dp.Value
+	}
+
+	require.Equal(t, map[string]datapoint.Value{
+		"web/Standard-2X":    datapoint.NewFloatValue(3),
+		"worker/Standard-1X": datapoint.NewFloatValue(0),
+	}, values)
+
+	// Process types scaled down to 0 are forgotten once reported
+	require.Len(t, tracker.formations, 1)
+
+	// The previous size of resized process types is reported as 0
+	tracker.setFormations(map[string]string{"app_name": "test"}, []*processFormation{
+		{ProcessType: "web", Quantity: 2, Size: "Performance-M"},
+	})
+	tracker.updateRegistry(reg)
+
+	values = map[string]datapoint.Value{}
+	for _, dp := range reg.Datapoints() {
+		if dp.Dimensions["process_type"] == "web" {
+			values[dp.Dimensions["dyno_size"]] = dp.Value
+		}
+	}
+
+	require.Equal(t, map[string]datapoint.Value{
+		"Standard-2X":   datapoint.NewFloatValue(0),
+		"Performance-M": datapoint.NewFloatValue(2),
+	}, values)
+	require.Empty(t, tracker.replaced)
+}
+
+func TestLoadAvgPerCPU(t *testing.T) {
+	conf := defaultConfig
+	conf.DefaultDynoSize = "Standard-1X"
+
+	listener := newTestListener(t, conf)
+
+	loadAvg := func(dyno string) float64 {
+		require.True(t, listener.processLine(`<45>1 2019-12-11T22:29:21.372436+00:00 host heroku `+dyno+` - source=`+dyno+` sample#load_avg_1m=3.00`,
+			map[string]string{"app_name": "test"}))
+
+		for _, dp := range listener.registry.Datapoints() {
+			if dp.Metric == "heroku.load_avg_per_cpu" && dp.Dimensions["dyno"] == dyno {
+				return dp.Value.(datapoint.FloatValue).Float()
+			}
+		}
+
+		return -1
+	}
+
+	// Process types that were not scaled have the default size
+	require.Equal(t, 3.0, loadAvg("web.1"))
+
+	require.True(t, listener.processLine(`<190>1 2020-01-13T18:27:50+00:00 host app api - Scaled to web@1:Performance-L by user user@example.com`,
+		map[string]string{"app_name": "test"}))
+	require.Equal(t, 3.0/8, loadAvg("web.1"))
+
+	// Dynos of unknown sizes have no load average per CPU
+	listener.defaultDynoSize = "Private-XL"
+	require.Equal(t, -1.0, loadAvg("worker.1"))
+}
+
+// The size of dynos is found whatever the other dimensions of their lines are
+func TestLoadAvgPerCPUExtraDimensions(t *testing.T) {
+	listener := newTestListener(t, defaultConfig)
+
+	dims := map[string]string{"app_name": "test", "env": "prod"}
+
+	require.True(t, listener.processLine(`<190>1 2020-01-13T18:27:50+00:00 host app api - Scaled to web@1:Performance-L by user user@example.com`, dims))
+	require.True(t, listener.processLine(`<45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.1 - source=web.1 sample#load_avg_1m=3.00 sfxdimension#region=us`, dims))
+
+	var loadAvgPerCPU []datapoint.Value
+	for _, dp := range listener.registry.Datapoints() {
+		if dp.Metric == "heroku.load_avg_per_cpu" {
+			loadAvgPerCPU = append(loadAvgPerCPU, dp.Value)
+		}
+	}
+
+	require.Equal(t, []datapoint.Value{datapoint.NewFloatValue(3.0 / 8)}, loadAvgPerCPU)
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/golib/v3/event"
+	log "github.com/sirupsen/logrus"
+)
+
+// Releases and deploys logged by the Heroku API. For more information, see:
+// https://devcenter.heroku.com/articles/releases
+var (
+	releaseFormat = regexp.MustCompile(`^Release (v\d+) created by (\S+)`)
+	deployFormat  = regexp.MustCompile(`^Deploy ([0-9a-f]+) by (\S+)`)
+)
+
+// Config var changes logged by the Heroku API, i.e.
+// "Set FOO, BAR config vars by user user@example.com". Only the names of
+// config vars are logged, never their values.
+var configChangeFormat = regexp.MustCompile(`^(Set|Remove) (.+?) config vars? by (?:user )?(\S+)`)
+
+// Returns the event logged in a line by the Heroku API, if any. Events have
+// the dimensions of the app the line is from.
+func (ll *logLine) herokuEvent(dims map[string]string) *event.Event {
+	if ll.Appname != "app" || ll.ProcID != "api" {
+		return nil
+	}
+
+	var eventType string
+
+	properties := map[string]interface{}{}
+
+	if match := releaseFormat.FindStringSubmatch(ll.Message); match != nil {
+		eventType = "heroku.release"
+		properties["version"] = match[1]
+		properties["actor"] = match[2]
+	} else if match := deployFormat.FindStringSubmatch(ll.Message); match != nil {
+		eventType = "heroku.deploy"
+		properties["commit"] = match[1]
+		properties["actor"] = match[2]
+	} else if match := configChangeFormat.FindStringSubmatch(ll.Message); match != nil {
+		eventType = "heroku.config_change"
+		properties["action"] = strings.ToLower(match[1])
+		properties["config_vars"] = strings.Join(splitList(match[2]), ",")
+		properties["actor"] = match[3]
+	} else {
+		return nil
+	}
+
+	ts, err := parseTimestamp(ll.Timestamp)
+	if err != nil {
+		ts = time.Now()
+	}
+
+	return event.NewWithProperties(eventType, event.USERDEFINED, mergeStringMaps(dims), properties, ts)
+}
+
+// Queues an event to be sent, dropping it if the queue is full so that log
+// lines keep being processed
+func (l *Listener) sendEvent(e *event.Event) {
+	if l.events == nil {
+		return
+	}
+
+	select {
+	case l.events <- e:
+		atomic.AddInt64(&l.queuedEvents, 1)
+	default:
+		atomic.AddInt64(&l.droppedEvents, 1)
+
+		log.WithFields(log.Fields{
+			"eventType": e.EventType,
+		}).Warn("Dropping event since the event queue is full")
+	}
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/event"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHerokuEvents(t *testing.T) {
+	dims := map[string]string{"app_name": "test-app"}
+
+	testCases := []struct {
+		line               string
+		expectedType       string
+		expectedProperties map[string]interface{}
+	}{
+		{
+			line:               `<190>1 2020-01-13T18:27:50+00:00 host app api - Release v123 created by user@example.com`,
+			expectedType:       "heroku.release",
+			expectedProperties: map[string]interface{}{"version": "v123", "actor": "user@example.com"},
+		},
+		{
+			line:               `<190>1 2020-01-13T18:27:50+00:00 host app api - Deploy 1a2b3c4 by user@example.com`,
+			expectedType:       "heroku.deploy",
+			expectedProperties: map[string]interface{}{"commit": "1a2b3c4", "actor": "user@example.com"},
+		},
+		{
+			line:               `<190>1 2020-01-13T18:27:50+00:00 host app api - Set FOO, BAR config vars by user user@example.com`,
+			expectedType:       "heroku.config_change",
+			expectedProperties: map[string]interface{}{"action": "set", "config_vars": "FOO,BAR", "actor": "user@example.com"},
+		},
+		{
+			line:               `<190>1 2020-01-13T18:27:50+00:00 host app api - Remove FOO config vars by user user@example.com`,
+			expectedType:       "heroku.config_change",
+			expectedProperties: map[string]interface{}{"action": "remove", "config_vars": "FOO", "actor": "user@example.com"},
+		},
+		{
+			line: `<190>1 2020-01-13T18:27:50+00:00 host app api - Scaled to web@1:Standard-1X by user user@example.com`,
+		},
+		{
+			// Only lines logged by the Heroku API are events
+			line: `<190>1 2020-01-13T18:27:50+00:00 host app web.1 - Release v123 created by user@example.com`,
+		},
+	}
+
+	for _, tc := range testCases {
+		ll, err := detectAndParseLog(tc.line)
+		require.NoError(t, err)
+
+		e := ll.herokuEvent(dims)
+
+		if tc.expectedType == "" {
+			require.Nilf(t, e, "Unexpected event for %s", tc.line)
+			continue
+		}
+
+		require.NotNilf(t, e, "Expected an event for %s", tc.line)
+		require.Equal(t, tc.expectedType, e.EventType)
+		require.Equal(t, event.USERDEFINED, e.Category)
+		require.Equal(t, dims, e.Dimensions)
+		require.Equal(t, tc.expectedProperties, e.Properties)
+		require.True(t, time.Date(2020, 1, 13, 18, 27, 50, 0, time.UTC).Equal(e.Timestamp))
+	}
+}
+
+func TestProcessLineEvents(t *testing.T) {
+	conf := defaultConfig
+	conf.IntervalSeconds = 1
+
+	eventChan := make(chan *event.Event, 1)
+	listener, err := NewListener(&conf, make(chan []*datapoint.Datapoint, 1), eventChan)
+	require.NoError(t, err)
+
+	line := `<190>1 2020-01-13T18:27:50+00:00 host app api - Release v123 created by user@example.com`
+
+	require.True(t, listener.processLine(line, map[string]string{"app_name": "test"}))
+	require.True(t, listener.processLine(line, map[string]string{"app_name": "test"}))
+
+	e := <-eventChan
+	require.Equal(t, "heroku.release", e.EventType)
+	require.Equal(t, "test", e.Dimensions["app_name"])
+
+	require.Equal(t, int64(1), listener.queuedEvents)
+	require.Equal(t, int64(1), listener.droppedEvents)
+}
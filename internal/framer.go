@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Logplex messages are limited to well under this size, anything larger than
+// this is most likely a corrupt length prefix
+const maxFrameLength = 1024 * 1024
+
+var (
+	errFrameMalformed = errors.New("frame does not start with a valid octet count")
+	errFrameTruncated = errors.New("frame is shorter than its declared octet count")
+	errFrameTooLarge  = errors.New("frame exceeds the maximum supported length")
+)
+
+// frameReader reads syslog messages framed using the octet-counting method
+// described in RFC 6587 (section 3.4.1), which is what Logplex uses for
+// the body of HTTPS drain requests. Each frame has the following form
+// "MSG-LEN SP SYSLOG-MSG", where MSG-LEN is the number of octets in SYSLOG-MSG.
+// For more information, see:
+// https://devcenter.heroku.com/articles/log-drains#https-drain-caveats
+type frameReader struct {
+	r *bufio.Reader
+
+	// Whether to also accept frames using the non-transparent framing
+	// method (RFC 6587 section 3.4.2), where each message ends with a
+	// newline, as is common with syslog over TCP
+	allowNonTransparent bool
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: bufio.NewReader(r)}
+}
+
+// Returns a frameReader for syslog streams (RFC 5425 and RFC 6587), where
+// senders may use either of the framing methods
+func newStreamFrameReader(r io.Reader) *frameReader {
+	return &frameReader{
+		r:                   bufio.NewReader(r),
+		allowNonTransparent: true,
+	}
+}
+
+// Next returns the next syslog message in the stream or io.EOF when there
+// are no more frames. When a frame is malformed or too large, an error is
+// returned and the reader moves past the offending frame so that the rest
+// of the stream can still be read. errFrameTruncated is always followed by
+// io.EOF since it's only returned when the stream ends in the middle of a frame.
+func (f *frameReader) Next() (string, error) {
+	if err := f.skipSeparators(); err != nil {
+		return "", err
+	}
+
+	// Syslog messages always start with "<PRI>", while octet-counted
+	// frames always start with a digit
+	if f.allowNonTransparent {
+		if b, err := f.r.Peek(1); err == nil && b[0] == '<' {
+			return f.readLine()
+		}
+	}
+
+	length, err := f.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	if length > maxFrameLength {
+		if _, err := io.CopyN(ioutil.Discard, f.r, int64(length)); err != nil {
+			return "", errFrameTruncated
+		}
+
+		return "", errFrameTooLarge
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(f.r, buf); err != nil {
+		return "", errFrameTruncated
+	}
+
+	// Logplex includes the trailing newline in the octet count
+	if length > 0 && buf[length-1] == '\n' {
+		buf = buf[:length-1]
+	}
+
+	return string(buf), nil
+}
+
+// Frames are expected to follow one another directly, but be lenient about
+// newlines and spaces in between them
+func (f *frameReader) skipSeparators() error {
+	for {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b != '\n' && b != '\r' && b != ' ' {
+			return f.r.UnreadByte()
+		}
+	}
+}
+
+// Reads the MSG-LEN part of the frame along with the following space. If
+// the frame does not start with a valid length, the rest of the line is
+// discarded since there's no reliable way of telling where the frame ends.
+func (f *frameReader) readLength() (int, error) {
+	var digits []byte
+
+	for {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return 0, errFrameTruncated
+			}
+
+			return 0, err
+		}
+
+		if b >= '0' && b <= '9' && len(digits) < 10 {
+			digits = append(digits, b)
+			continue
+		}
+
+		if b == ' ' && len(digits) > 0 {
+			break
+		}
+
+		if b != '\n' {
+			if err := f.discardLine(); err != nil && err != io.EOF {
+				return 0, err
+			}
+		}
+
+		return 0, errFrameMalformed
+	}
+
+	length, err := strconv.Atoi(string(digits))
+	if err != nil {
+		return 0, errFrameMalformed
+	}
+
+	return length, nil
+}
+
+// Reads a newline terminated frame
+func (f *frameReader) readLine() (string, error) {
+	var line []byte
+
+	for {
+		chunk, err := f.r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxFrameLength {
+			if err == bufio.ErrBufferFull {
+				if err := f.discardLine(); err != nil && err != io.EOF {
+					return "", err
+				}
+			}
+
+			return "", errFrameTooLarge
+		}
+
+		line = append(line, chunk...)
+
+		switch err {
+		case nil:
+			return strings.TrimRight(string(line), "\r\n"), nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			// The last message in the stream may not have a trailing newline
+			return string(line), nil
+		default:
+			return "", err
+		}
+	}
+}
+
+func (f *frameReader) discardLine() error {
+	_, err := f.r.ReadSlice('\n')
+
+	for err == bufio.ErrBufferFull {
+		_, err = f.r.ReadSlice('\n')
+	}
+
+	return err
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readAllFrames(body string) ([]string, []error) {
+	frames := newFrameReader(strings.NewReader(body))
+
+	var lines []string
+
+	var errs []error
+
+	for {
+		line, err := frames.Next()
+		if err == io.EOF {
+			return lines, errs
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+
+			if err == errFrameTruncated {
+				return lines, errs
+			}
+
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+}
+
+func TestFrameReader(t *testing.T) {
+	first := "<40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed from starting to up\n"
+	second := "<40>1 2012-11-30T06:45:26+00:00 host app web.3 - Starting process with command `bundle exec rackup`\nand a second line\n"
+
+	lines, errs := readAllFrames("83 " + first + "118 " + second)
+	require.Empty(t, errs)
+	require.Equal(t, []string{strings.TrimSuffix(first, "\n"), strings.TrimSuffix(second, "\n")}, lines)
+
+	// Frames without a length prefix are skipped without dropping the rest
+	lines, errs = readAllFrames("<40>1 garbage\n83 " + first)
+	require.Equal(t, []error{errFrameMalformed}, errs)
+	require.Equal(t, []string{strings.TrimSuffix(first, "\n")}, lines)
+
+	lines, errs = readAllFrames("83 " + first + "500 " + first)
+	require.Equal(t, []error{errFrameTruncated}, errs)
+	require.Len(t, lines, 1)
+
+	lines, errs = readAllFrames("2000000 " + strings.Repeat("a", 2000000) + "83 " + first)
+	require.Equal(t, []error{errFrameTooLarge}, errs)
+	require.Len(t, lines, 1)
+
+	lines, errs = readAllFrames("")
+	require.Empty(t, errs)
+	require.Empty(t, lines)
+}
+
+func TestStreamFrameReader(t *testing.T) {
+	frames := newStreamFrameReader(strings.NewReader("<40>1 newline terminated\r\n11 <40>1 octet<40>1 no trailing newline"))
+
+	var lines []string
+
+	for {
+		line, err := frames.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		lines = append(lines, line)
+	}
+
+	require.Equal(t, []string{"<40>1 newline terminated", "<40>1 octet", "<40>1 no trailing newline"}, lines)
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+	log "github.com/sirupsen/logrus"
+)
+
+// JSONMapping says which fields of JSON log messages are metrics and which
+// are dimensions. Fields are referred to by their path, with the keys of
+// nested objects separated by dots, i.e. "db.duration".
+type JSONMapping struct {
+	// Metric types by the path of the fields holding their value. Metrics
+	// are named after the path. Types are one of "counter", "gauge",
+	// "cumulative" or "histogram".
+	Metrics map[string]string `json:"metrics"`
+	// Dimension names by the path of the fields holding their value
+	Dimensions map[string]string `json:"dimensions"`
+}
+
+var jsonMetricTypes = map[string]datapoint.MetricType{
+	"counter":    datapoint.Count,
+	"gauge":      datapoint.Gauge,
+	"cumulative": datapoint.Counter,
+	"histogram":  registry.Histogram,
+}
+
+func (m *JSONMapping) validate() error {
+	for path, metricType := range m.Metrics {
+		if _, ok := jsonMetricTypes[metricType]; !ok {
+			return fmt.Errorf("JSON field %s has unsupported metric type %q", path, metricType)
+		}
+	}
+
+	return nil
+}
+
+// Returns the metrics and dimensions mapped from the fields of a JSON log
+// message. Fields that are missing or not numeric are skipped.
+func (m *JSONMapping) apply(obj map[string]interface{}) ([]*registry.MetricVal, map[string]string) {
+	var metrics []*registry.MetricVal
+
+	for path, metricType := range m.Metrics {
+		value, ok := jsonPath(obj, path)
+		if !ok {
+			continue
+		}
+
+		numericValue, err := jsonNumericValue(value)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"field": path,
+			}).Debug("Unable to get value of JSON field. Will be dropped.")
+
+			continue
+		}
+
+		metrics = append(metrics, &registry.MetricVal{
+			Name:  path,
+			Type:  jsonMetricTypes[metricType],
+			Value: numericValue,
+		})
+	}
+
+	dims := map[string]string{}
+
+	for path, name := range m.Dimensions {
+		value, ok := jsonPath(obj, path)
+		if !ok {
+			continue
+		}
+
+		if s, ok := jsonScalarString(value); ok && s != "" {
+			dims[name] = s
+		}
+	}
+
+	return metrics, dims
+}
+
+// Returns the JSON object in a log message, or nil if the message is not a
+// JSON object
+func parseJSONMessage(message string) map[string]interface{} {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "{") {
+		return nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &obj); err != nil {
+		return nil
+	}
+
+	return obj
+}
+
+// Returns the top level fields of a JSON object that have a scalar value
+// as key/value pairs, so that they are processed as if they were logged in
+// the logfmt format, i.e. {"gauge#queue": 3}
+func jsonPairs(obj map[string]interface{}) []logfmtPair {
+	pairs := make([]logfmtPair, 0, len(obj))
+
+	for key, value := range obj {
+		if s, ok := jsonScalarString(value); ok {
+			pairs = append(pairs, logfmtPair{Key: key, Value: s})
+		}
+	}
+
+	return pairs
+}
+
+// Returns the value at the given path in a JSON object. Keys containing dots
+// are matched as well, i.e. "db.duration" matches {"db.duration": 1}.
+func jsonPath(obj map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := obj[path]; ok {
+		return value, true
+	}
+
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+
+		nested, ok := obj[path[:i]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if value, ok := jsonPath(nested, path[i+1:]); ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+func jsonScalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// Numbers are used as is, and strings are parsed the same way as logfmt
+// values, so that they can have units, i.e. "12ms"
+func jsonNumericValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		numericValue, err := getNumericValue(v)
+		if err != nil {
+			return 0, err
+		}
+
+		return *numericValue, nil
+	default:
+		return 0, fmt.Errorf("unsupported JSON value %v", value)
+	}
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONMapping(t *testing.T) {
+	mapping := &JSONMapping{
+		Metrics: map[string]string{
+			"duration_ms":   "histogram",
+			"db.time":       "gauge",
+			"response.size": "counter",
+			"missing":       "gauge",
+			"service":       "gauge",
+		},
+		Dimensions: map[string]string{
+			"service":       "service",
+			"request.route": "route",
+			"request":       "request",
+		},
+	}
+	require.NoError(t, mapping.validate())
+
+	obj := parseJSONMessage(`{"service": "checkout", "duration_ms": 120, "db.time": "15ms", "response": {"size": 512}, "request": {"route": "/orders/:id"}}`)
+	require.NotNil(t, obj)
+
+	metrics, dims := mapping.apply(obj)
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+
+	require.Equal(t, []*registry.MetricVal{
+		{Name: "db.time", Type: datapoint.Gauge, Value: 15},
+		{Name: "duration_ms", Type: registry.Histogram, Value: 120},
+		{Name: "response.size", Type: datapoint.Count, Value: 512},
+	}, metrics)
+
+	require.Equal(t, map[string]string{"service": "checkout", "route": "/orders/:id"}, dims)
+
+	require.Error(t, (&JSONMapping{Metrics: map[string]string{"duration_ms": "summary"}}).validate())
+}
+
+func TestParseJSONMessage(t *testing.T) {
+	require.NotNil(t, parseJSONMessage(` {"a": 1} `))
+	require.Nil(t, parseJSONMessage(`at=info method=GET`))
+	require.Nil(t, parseJSONMessage(`{"a": 1} trailing`))
+	require.Nil(t, parseJSONMessage(`[1, 2]`))
+}
+
+func TestMessageJSONDecodedOnce(t *testing.T) {
+	ll := &logLine{Message: `{"gauge#queue": 3}`}
+
+	obj := ll.messageJSON()
+	require.NotNil(t, obj)
+
+	// The decoded message is reused rather than decoded again
+	ll.Message = "gauge#queue=3"
+	obj["decoded"] = true
+	require.Equal(t, true, ll.messageJSON()["decoded"])
+}
+
+func TestProcessMetricsJSONMessage(t *testing.T) {
+	ll, err := detectAndParseLog(`<190>1 2020-01-13T18:27:50+00:00 host app web.1 - {"gauge#queue": 3, "counter#jobs": "2", "sfxdimension#team": "data platform", "message": "done", "nested": {"gauge#ignored": 1}}`)
+	require.NoError(t, err)
+
+	metrics, dims := processMetrics(ll, map[string]string{"app_name": "test-app"})
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+
+	require.Equal(t, []*registry.MetricVal{
+		{Name: "jobs", Type: datapoint.Count, Value: 2},
+		{Name: "queue", Type: datapoint.Gauge, Value: 3},
+	}, metrics)
+
+	require.Equal(t, "data platform", dims["team"])
+}
@@ -1,43 +1,199 @@
 package internal
 
 import (
-	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/event"
 	"github.com/signalfx/golib/v3/sfxclient"
 	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	errBodyTooLarge        = errors.New("request body is larger than the maximum allowed size")
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+var supportedContentTypes = makeStringSet("application/logplex-1", "text/plain", "application/octet-stream")
+
+// Status codes returned by the drain handler
+var responseStatusCodes = []int{
+	http.StatusNoContent,
+	http.StatusBadRequest,
+	http.StatusUnauthorized,
+	http.StatusRequestEntityTooLarge,
+	http.StatusUnsupportedMediaType,
+	http.StatusServiceUnavailable,
+}
+
 type Listener struct {
 	dps                     chan<- []*datapoint.Datapoint
+	events                  chan<- *event.Event
 	metricsToExclude        map[string]bool
 	dimensionPairsToExclude map[string]string
 	registry                *registry.MetricRegistry
 	intervalSeconds         int
-	totalRequests           int64
+	dedup                   *frameDeduplicator
+	inFlight                chan struct{}
+	// Syslog connections are long-lived, so they are limited separately
+	// from drain requests to not starve them
+	syslogConns chan struct{}
+	dynos       *dynoTracker
+
+	maxBodyBytes             int64
+	maxDecompressedBodyBytes int64
+
+	drainUsername  string
+	drainPassword  string
+	drainTokens    map[string]bool
+	drainTokenDims map[string]map[string]string
+	syslogNameDims map[string]map[string]string
+	udpSources     []SyslogSource
+
+	structuredDataDims []string
+	addonPlans         map[string]string
+	logRules           []*compiledLogRule
+	jsonMapping        *JSONMapping
+	dynoSizeCPUs       map[string]float64
+	defaultDynoSize    string
+	routerPaths        *routerPathNormalizer
+
+	useLogTimestamps bool
+	lateTolerance    time.Duration
+	futureTolerance  time.Duration
+
+	// Internal metrics
+	totalRequests             int64
+	totalFrames               int64
+	malformedFrames           int64
+	truncatedFrames           int64
+	oversizedFrames           int64
+	msgCountMismatches        int64
+	duplicateRequests         int64
+	compressedBytes           int64
+	uncompressedBytes         int64
+	unmappedLines             int64
+	unauthorizedLines         int64
+	unsupportedLines          int64
+	syslogConnections         int64
+	rejectedSyslogConnections int64
+	receivedDatagrams         int64
+	parsedDatagrams           int64
+	droppedDatagrams          int64
+	lateLines                 int64
+	futureLines               int64
+	queuedEvents              int64
+	droppedEvents             int64
+	responses                 map[int]*int64
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
-func NewListener(intervalSeconds int, dpChan chan<- []*datapoint.Datapoint) (*Listener, error) {
+// NewListener returns a listener sending datapoints to dpChan, and events
+// to eventChan. Events are not sent if eventChan is nil.
+func NewListener(conf *Config, dpChan chan<- []*datapoint.Datapoint, eventChan chan<- *event.Event) (*Listener, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	l := &Listener{
-		dps:             dpChan,
-		registry:        registry.New(5 * time.Minute),
-		ctx:             ctx,
-		cancel:          cancel,
-		intervalSeconds: intervalSeconds,
+		dps:                      dpChan,
+		events:                   eventChan,
+		registry:                 registry.New(registryExpiry(conf), conf.HistogramPercentiles),
+		ctx:                      ctx,
+		cancel:                   cancel,
+		intervalSeconds:          conf.IntervalSeconds,
+		maxBodyBytes:             conf.MaxBodyBytes,
+		maxDecompressedBodyBytes: conf.MaxDecompressedBodyBytes,
+		drainUsername:            conf.DrainUsername,
+		drainPassword:            conf.DrainPassword,
+		drainTokens:              conf.DrainTokens,
+		drainTokenDims:           conf.DrainTokenMapping,
+		syslogNameDims:           conf.SyslogNameMapping,
+		udpSources:               conf.SyslogUDPSources,
+		structuredDataDims:       conf.StructuredDataDimensions,
+		addonPlans:               conf.AddonPlans,
+		dynoSizeCPUs:             conf.DynoSizeCPUs,
+		defaultDynoSize:          conf.DefaultDynoSize,
+		useLogTimestamps:         conf.UseLogTimestamps,
+		lateTolerance:            time.Duration(conf.LateLineToleranceSeconds) * time.Second,
+		futureTolerance:          time.Duration(conf.FutureLineToleranceSeconds) * time.Second,
+		responses:                map[int]*int64{},
+		dynos:                    newDynoTracker(),
+	}
+
+	for _, status := range responseStatusCodes {
+		l.responses[status] = new(int64)
+	}
+
+	logRules, err := compileLogRules(conf.LogRules)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	l.logRules = logRules
+
+	if conf.JSONMapping != nil {
+		if err := conf.JSONMapping.validate(); err != nil {
+			cancel()
+			return nil, err
+		}
+
+		l.jsonMapping = conf.JSONMapping
+	}
+
+	if conf.RouterPathDimension {
+		routerPaths, err := newRouterPathNormalizer(conf.RouterPathTemplates, conf.MaxRouterPaths)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		l.routerPaths = routerPaths
+	}
+
+	if conf.MaxConcurrentRequests > 0 {
+		l.inFlight = make(chan struct{}, conf.MaxConcurrentRequests)
+		l.syslogConns = make(chan struct{}, conf.MaxConcurrentRequests)
+	}
+
+	if conf.DedupWindowSeconds > 0 {
+		l.dedup = newFrameDeduplicator(time.Duration(conf.DedupWindowSeconds)*time.Second, conf.DedupMaxFrameIDs)
 	}
 
 	return l, nil
 }
 
+// Metrics are expired after 5 minutes without updates, unless lines can be
+// late for longer than that, in which case counts for old timestamps need
+// to be kept around for as long
+func registryExpiry(conf *Config) time.Duration {
+	expiry := 5 * time.Minute
+
+	if conf.UseLogTimestamps {
+		lateExpiry := time.Duration(conf.LateLineToleranceSeconds+conf.IntervalSeconds) * time.Second
+		if lateExpiry > expiry {
+			expiry = lateExpiry
+		}
+	}
+
+	return expiry
+}
+
 func (l *Listener) Start() error {
 	log.WithFields(log.Fields{
 		"intervalSeconds": l.intervalSeconds,
@@ -50,6 +206,8 @@ func (l *Listener) Start() error {
 		for {
 			select {
 			case <-ticker.C:
+				l.dynos.updateRegistry(l.registry)
+
 				dps := l.registry.Datapoints()
 
 				// Use n + loop to shift up valid datapoints in the slice
@@ -88,42 +246,531 @@ func (l *Listener) shouldDispatch(datapoint *datapoint.Datapoint) bool {
 func (l *Listener) ProcessLogs(w http.ResponseWriter, req *http.Request) {
 	atomic.AddInt64(&l.totalRequests, 1)
 
-	dims, err := getDimensionPairsFromParams(req.URL.Query())
+	if !l.isAuthorized(req) {
+		if l.drainUsername != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="heroku-signalfx-collector"`)
+		}
 
-	if err != nil {
+		l.respond(w, http.StatusUnauthorized, "unauthorized")
+
+		return
+	}
+
+	if !isSupportedContentType(req.Header.Get("Content-Type")) {
+		l.respond(w, http.StatusUnsupportedMediaType, "unsupported content type")
+		return
+	}
+
+	dims := getDimensionPairsFromParams(req.URL.Query(), l.drainTokenDims[req.Header.Get("Logplex-Drain-Token")])
+
+	// Lines can still be mapped to an app by their syslog hostname or
+	// appname fields, otherwise app_name is required
+	if dims["app_name"] == "" && len(l.syslogNameDims) == 0 {
 		log.WithFields(log.Fields{
-			"error":  err,
 			"params": req.URL.Query().Encode(),
-		}).Error("Unable to get App name from request param (appname)")
+		}).Error("Unable to get App name from request param (app_name) or drain token")
+
+		l.respond(w, http.StatusBadRequest, "app_name parameter is required for unmapped drains")
 
 		return
 	}
 
-	scanner := bufio.NewScanner(req.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
+	// Ask Logplex to back off and retry later instead of queueing up an
+	// unbounded number of requests
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+			defer func() { <-l.inFlight }()
+		default:
+			w.Header().Set("Retry-After", "1")
+			l.respond(w, http.StatusServiceUnavailable, "too many concurrent drain requests")
 
-		processedLog, err := detectAndParseLog(line)
+			return
+		}
+	}
 
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"line":  line,
-			}).Error("Error processing supported log line")
+	body, err := l.readBody(req)
+	if err != nil {
+		status := http.StatusBadRequest
+
+		switch err {
+		case errBodyTooLarge:
+			status = http.StatusRequestEntityTooLarge
+		case errUnsupportedEncoding:
+			status = http.StatusUnsupportedMediaType
+		}
+
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Unable to read drain request body")
+
+		l.respond(w, status, err.Error())
+
+		return
+	}
+
+	if l.isRetry(req) {
+		atomic.AddInt64(&l.duplicateRequests, 1)
+
+		log.WithFields(log.Fields{
+			"frameID": req.Header.Get("Logplex-Frame-Id"),
+		}).Debug("Discarding drain request that was already processed")
+
+		// Still acknowledge the request so that Logplex stops retrying
+		l.respond(w, http.StatusNoContent, "")
+
+		return
+	}
+
+	numFrames, numInvalidFrames := l.processFrames(newFrameReader(bytes.NewReader(body)), dims, l.processLine)
+
+	l.checkMessageCount(req.Header.Get("Logplex-Msg-Count"), numFrames)
+
+	if numFrames == 0 && numInvalidFrames > 0 {
+		l.respond(w, http.StatusBadRequest, "no valid frames in request body")
+		return
+	}
+
+	l.respond(w, http.StatusNoContent, "")
+}
+
+// Checks the credentials embedded in the drain URL and the drain token of
+// the request against the ones configured, if any
+func (l *Listener) isAuthorized(req *http.Request) bool {
+	if l.drainUsername != "" {
+		username, password, ok := req.BasicAuth()
+		if !ok {
+			return false
+		}
+
+		// Compare both values regardless of the result of the first
+		// comparison to not leak timing information
+		usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(l.drainUsername)) == 1
+		passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(l.drainPassword)) == 1
+
+		if !usernameMatches || !passwordMatches {
+			return false
+		}
+	}
+
+	if len(l.drainTokens) > 0 && !l.drainTokens[req.Header.Get("Logplex-Drain-Token")] {
+		return false
+	}
+
+	return true
+}
+
+// Logplex sends "application/logplex-1", other senders are expected to
+// send octet-counted syslog frames as plain text
+func isSupportedContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return supportedContentTypes[mediaType]
+}
+
+// Reads the whole body of a drain request, decompressing it if needed, making
+// sure it is not larger than the configured maximum body sizes
+func (l *Listener) readBody(req *http.Request) ([]byte, error) {
+	if l.maxBodyBytes > 0 && req.ContentLength > l.maxBodyBytes {
+		return nil, errBodyTooLarge
+	}
+
+	body, err := readAllWithLimit(req.Body, l.maxBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		atomic.AddInt64(&l.uncompressedBytes, int64(len(body)))
+		return body, nil
+	}
+
+	atomic.AddInt64(&l.compressedBytes, int64(len(body)))
+
+	var decompressor io.ReadCloser
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		decompressor, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// Deflate is supposed to be zlib wrapped, but some senders
+		// send raw deflate data instead
+		decompressor, err = zlib.NewReader(bytes.NewReader(body))
+		if err == zlib.ErrHeader {
+			decompressor, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, errUnsupportedEncoding
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	// Protect against decompression bombs by not trusting the size of the
+	// compressed body
+	body, err = readAllWithLimit(decompressor, l.maxDecompressedBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&l.uncompressedBytes, int64(len(body)))
+
+	return body, nil
+}
+
+// Returns errBodyTooLarge if there are more than max bytes to read. No
+// limit is applied when max is 0.
+func readAllWithLimit(r io.Reader, max int64) ([]byte, error) {
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if max > 0 && int64(len(body)) > max {
+		return nil, errBodyTooLarge
+	}
+
+	return body, nil
+}
+
+func (l *Listener) respond(w http.ResponseWriter, status int, msg string) {
+	if counter := l.responses[status]; counter != nil {
+		atomic.AddInt64(counter, 1)
+	}
+
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+
+	http.Error(w, msg, status)
+}
+
+// Returns true if Logplex already delivered the same frame recently
+func (l *Listener) isRetry(req *http.Request) bool {
+	frameID := req.Header.Get("Logplex-Frame-Id")
+	if l.dedup == nil || frameID == "" {
+		return false
+	}
+
+	return l.dedup.isDuplicate(req.Header.Get("Logplex-Drain-Token"), frameID)
+}
+
+// Reads frames from the body of a drain request, or a syslog connection, and
+// processes each of them. Returns the number of valid and invalid frames that
+// were read.
+func (l *Listener) processFrames(frames *frameReader, dims map[string]string, processLine func(string, map[string]string) bool) (int, int) {
+	numFrames := 0
+	numInvalidFrames := 0
+
+	for {
+		line, err := frames.Next()
+
+		switch err {
+		case nil:
+			numFrames++
+			atomic.AddInt64(&l.totalFrames, 1)
+			processLine(line, dims)
 
 			continue
+		case io.EOF:
+			return numFrames, numInvalidFrames
+		case errFrameMalformed:
+			atomic.AddInt64(&l.malformedFrames, 1)
+		case errFrameTooLarge:
+			atomic.AddInt64(&l.oversizedFrames, 1)
+		case errFrameTruncated:
+			atomic.AddInt64(&l.truncatedFrames, 1)
+		}
+
+		numInvalidFrames++
+
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Unable to read frame")
+
+		// Malformed and oversized frames are skipped over by the
+		// reader, anything else means the rest of the body is unusable
+		if err != errFrameMalformed && err != errFrameTooLarge {
+			return numFrames, numInvalidFrames
+		}
+	}
+}
+
+// Returns true if the line was in a supported format and could be mapped to an app
+func (l *Listener) processLine(line string, dims map[string]string) bool {
+	processedLog := l.parseLine(line)
+	if processedLog == nil {
+		return false
+	}
+
+	return l.processLogLine(processedLog, dims)
+}
+
+// Same as processLine, for lines received over syslog connections, which are
+// not authenticated like drain requests. Syslog drains set the hostname to
+// the drain token, so lines are only processed if their hostname is one of
+// the allowed drain tokens, if any.
+func (l *Listener) processSyslogLine(line string, dims map[string]string) bool {
+	processedLog := l.parseLine(line)
+	if processedLog == nil {
+		return false
+	}
+
+	if len(l.drainTokens) > 0 && !l.drainTokens[processedLog.Hostname] {
+		atomic.AddInt64(&l.unauthorizedLines, 1)
+
+		log.WithFields(log.Fields{
+			"hostname": processedLog.Hostname,
+		}).Debug("Dropping syslog line from a drain token that is not allowed")
+
+		return false
+	}
+
+	return l.processLogLine(processedLog, dims)
+}
+
+// Returns nil if the line could not be parsed or is in an unsupported format
+func (l *Listener) parseLine(line string) *logLine {
+	processedLog, err := detectAndParseLog(line)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"line":  line,
+		}).Error("Error processing supported log line")
+
+		return nil
+	}
+
+	if processedLog == nil {
+		atomic.AddInt64(&l.unsupportedLines, 1)
+		return nil
+	}
+
+	return processedLog
+}
+
+func (l *Listener) processLogLine(processedLog *logLine, dims map[string]string) bool {
+	dims = l.lineDimensions(processedLog, dims)
+	if dims["app_name"] == "" {
+		atomic.AddInt64(&l.unmappedLines, 1)
+
+		log.WithFields(log.Fields{
+			"hostname": processedLog.Hostname,
+			"appname":  processedLog.Appname,
+		}).Debug("Dropping log line that could not be mapped to an app")
+
+		return false
+	}
+
+	ts, ok := l.lineTimestamp(processedLog)
+	if !ok {
+		return false
+	}
+
+	if e := processedLog.herokuEvent(dims); e != nil {
+		l.sendEvent(e)
+	}
+
+	if formations := processedLog.herokuFormations(); formations != nil {
+		l.dynos.setFormations(dims, formations)
+	}
+
+	metrics, dims := processMetrics(processedLog, dims)
+	metrics = append(metrics, applyLogRules(l.logRules, processedLog)...)
+
+	if l.jsonMapping != nil {
+		if obj := processedLog.messageJSON(); obj != nil {
+			jsonMetrics, jsonDims := l.jsonMapping.apply(obj)
+
+			// Fields of the message can't override the dimensions of the
+			// drain or the line, i.e. the app name
+			metrics = append(metrics, jsonMetrics...)
+			dims = mergeStringMaps(jsonDims, dims)
+		}
+	}
+
+	if processedLog.Appname == "heroku" && dims["process_type"] != "" {
+		if metric := loadAvgPerCPUMetric(metrics, l.dynoCPUs(dims)); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	if l.routerPaths != nil && processedLog.Appname == "heroku" && processedLog.ProcID == "router" {
+		if path := processedLog.messageValue("path"); path != "" {
+			dims["path"] = l.routerPaths.normalize(dims["app_name"], path)
+		}
+	}
+
+	if plan := l.addonPlans[dims["addon"]]; plan != "" {
+		dims["plan"] = plan
+	}
+
+	for _, metric := range metrics {
+		if metric.Name == dynoStateChangesMetric {
+			l.dynos.setState(dims, processedLog.ProcID, metric.Dimensions["to"])
+		}
+	}
+
+	if !ts.IsZero() {
+		for _, metric := range metrics {
+			if metric.Type == datapoint.Gauge || metric.Type == datapoint.Count {
+				metric.Timestamp = ts
+			}
 		}
+	}
+
+	l.registry.UpdateMetrics(metrics, dims)
+
+	return true
+}
+
+// Returns the timestamp of a log line truncated to the reporting interval,
+// or a zero time if log timestamps are not used or the line does not have
+// a usable timestamp (RFC 3164 timestamps have neither a year nor a time
+// zone). Returns false if the line is outside the configured tolerances.
+func (l *Listener) lineTimestamp(ll *logLine) (time.Time, bool) {
+	if !l.useLogTimestamps || ll.Version == "" || ll.Timestamp == nilValue {
+		return time.Time{}, true
+	}
+
+	ts, err := parseTimestamp(ll.Timestamp)
+	if err != nil {
+		return time.Time{}, true
+	}
+
+	now := time.Now()
+
+	if ts.Before(now.Add(-l.lateTolerance)) {
+		atomic.AddInt64(&l.lateLines, 1)
+
+		log.WithFields(log.Fields{
+			"timestamp": ll.Timestamp,
+		}).Debug("Dropping log line older than the late line tolerance")
+
+		return time.Time{}, false
+	}
+
+	if ts.After(now.Add(l.futureTolerance)) {
+		atomic.AddInt64(&l.futureLines, 1)
+
+		log.WithFields(log.Fields{
+			"timestamp": ll.Timestamp,
+		}).Debug("Dropping log line newer than the future line tolerance")
+
+		return time.Time{}, false
+	}
+
+	return ts.Truncate(time.Duration(l.intervalSeconds) * time.Second), true
+}
+
+// Adds dimensions mapped from the syslog fields of a log line. Syslog drains
+// set the hostname to the drain token, so the hostname is looked up in both
+// the drain token and syslog name mappings. Dimensions from the drain request
+// or connection take precedence over the mapped ones. An app_name parameter
+// in the structured data of the line is only used when the line can't be
+// mapped to an app otherwise, so that senders can't report metrics for other
+// apps. Structured data parameters configured as dimensions are added as well.
+func (l *Listener) lineDimensions(ll *logLine, dims map[string]string) map[string]string {
+	mappedDims := l.drainTokenDims[ll.Hostname]
+	if mappedDims == nil {
+		mappedDims = l.syslogNameDims[ll.Hostname]
+	}
+
+	if mappedDims == nil {
+		mappedDims = l.syslogNameDims[ll.Appname]
+	}
+
+	if mappedDims != nil {
+		dims = mergeStringMaps(mappedDims, dims)
+	}
+
+	if appName := structuredDataParam(ll.StructuredData, "app_name"); appName != "" && dims["app_name"] == "" {
+		dims = mergeStringMaps(dims, map[string]string{"app_name": appName})
+	}
 
-		if processedLog != nil {
-			metrics, dims := processMetrics(processedLog, dims)
-			l.registry.UpdateMetrics(metrics, dims)
+	for _, name := range l.structuredDataDims {
+		if value := structuredDataParam(ll.StructuredData, name); value != "" {
+			dims = mergeStringMaps(dims, map[string]string{name: value})
 		}
 	}
+
+	return dims
+}
+
+// Logplex sets the Logplex-Msg-Count header to the number of messages in
+// the request body. A mismatch means that some messages were not framed
+// correctly and have been lost.
+func (l *Listener) checkMessageCount(header string, numFrames int) {
+	if header == "" {
+		return
+	}
+
+	expected, err := strconv.Atoi(header)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"header": header,
+		}).Debug("Invalid Logplex-Msg-Count header")
+
+		return
+	}
+
+	if expected != numFrames {
+		atomic.AddInt64(&l.msgCountMismatches, 1)
+
+		log.WithFields(log.Fields{
+			"expected": expected,
+			"actual":   numFrames,
+		}).Warn("Number of frames in drain request does not match Logplex-Msg-Count")
+	}
 }
 
 func (l *Listener) InternalMetrics() []*datapoint.Datapoint {
-	return append(l.registry.InternalMetrics(), []*datapoint.Datapoint{
+	dps := l.registry.InternalMetrics()
+
+	for _, status := range responseStatusCodes {
+		dps = append(dps, sfxclient.CumulativeP("sfx_heroku.drain_responses",
+			map[string]string{"status_code": strconv.Itoa(status)}, l.responses[status]))
+	}
+
+	return append(dps, []*datapoint.Datapoint{
 		sfxclient.CumulativeP("sfx_heroku.total_drain_requests", nil, &l.totalRequests),
+		sfxclient.CumulativeP("sfx_heroku.total_frames", nil, &l.totalFrames),
+		sfxclient.CumulativeP("sfx_heroku.invalid_frames", map[string]string{"reason": "malformed"}, &l.malformedFrames),
+		sfxclient.CumulativeP("sfx_heroku.invalid_frames", map[string]string{"reason": "truncated"}, &l.truncatedFrames),
+		sfxclient.CumulativeP("sfx_heroku.invalid_frames", map[string]string{"reason": "too_large"}, &l.oversizedFrames),
+		sfxclient.CumulativeP("sfx_heroku.msg_count_mismatches", nil, &l.msgCountMismatches),
+		sfxclient.CumulativeP("sfx_heroku.duplicate_drain_requests", nil, &l.duplicateRequests),
+		sfxclient.CumulativeP("sfx_heroku.unmapped_log_lines", nil, &l.unmappedLines),
+		sfxclient.CumulativeP("sfx_heroku.unsupported_log_lines", nil, &l.unsupportedLines),
+		sfxclient.CumulativeP("sfx_heroku.unauthorized_syslog_lines", nil, &l.unauthorizedLines),
+		sfxclient.CumulativeP("sfx_heroku.syslog_connections", nil, &l.syslogConnections),
+		sfxclient.CumulativeP("sfx_heroku.rejected_syslog_connections", nil, &l.rejectedSyslogConnections),
+		sfxclient.CumulativeP("sfx_heroku.syslog_udp_datagrams", map[string]string{"status": "received"}, &l.receivedDatagrams),
+		sfxclient.CumulativeP("sfx_heroku.syslog_udp_datagrams", map[string]string{"status": "parsed"}, &l.parsedDatagrams),
+		sfxclient.CumulativeP("sfx_heroku.syslog_udp_datagrams", map[string]string{"status": "dropped"}, &l.droppedDatagrams),
+		sfxclient.CumulativeP("sfx_heroku.lines_outside_time_window", map[string]string{"reason": "late"}, &l.lateLines),
+		sfxclient.CumulativeP("sfx_heroku.lines_outside_time_window", map[string]string{"reason": "future"}, &l.futureLines),
+		sfxclient.CumulativeP("sfx_heroku.events", map[string]string{"status": "queued"}, &l.queuedEvents),
+		sfxclient.CumulativeP("sfx_heroku.events", map[string]string{"status": "dropped"}, &l.droppedEvents),
+		sfxclient.CumulativeP("sfx_heroku.received_bytes", map[string]string{"type": "compressed"}, &l.compressedBytes),
+		sfxclient.CumulativeP("sfx_heroku.received_bytes", map[string]string{"type": "uncompressed"}, &l.uncompressedBytes),
 	}...)
 }
 
@@ -2,18 +2,25 @@ package internal
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/stretchr/testify/require"
 )
 
 //nolint:funlen
 func TestListenerStartWithFilter(t *testing.T) {
 	dpChan := make(chan []*datapoint.Datapoint, 1)
 
-	listener, err := NewListener(1, dpChan)
+	conf := defaultConfig
+	conf.IntervalSeconds = 1
+
+	listener, err := NewListener(&conf, dpChan, nil)
 	if err != nil {
 		t.Logf("Failed to setup listener")
 	}
@@ -27,12 +34,12 @@ func TestListenerStartWithFilter(t *testing.T) {
 		"heroku.memory_total": true,
 	}
 
-	logLine := "277 <45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.1 - source=web.1 dyno=heroku.155370883.259625dd-a9c7-4987-9c86-08de28dd4f72 sample#memory_total=99.74MB sample#memory_rss=97.91MB"
+	logLine := "184 <45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.1 - source=web.1 dyno=heroku.155370883.259625dd-a9c7-4987-9c86-08de28dd4f72 sample#memory_total=99.74MB sample#memory_rss=97.91MB"
 
 	req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(logLine)))
 	req.URL.RawQuery = "app_name=test"
 
-	listener.ProcessLogs(nil, req)
+	listener.ProcessLogs(httptest.NewRecorder(), req)
 
 	checkDatapoints(dpChan, listener.metricsToExclude, listener.dimensionPairsToExclude, t)
 
@@ -43,16 +50,26 @@ func TestListenerStartWithFilter(t *testing.T) {
 		"source": "web.2",
 	}
 
-	logLine = "277 <45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.2 - source=web.2 dyno=heroku.155370883.259625dd-a9c7-4987-9c86-08de28dd4f72 sample#memory_total=99.74MB sample#memory_rss=97.91MB"
+	logLine = "184 <45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.2 - source=web.2 dyno=heroku.155370883.259625dd-a9c7-4987-9c86-08de28dd4f72 sample#memory_total=99.74MB sample#memory_rss=97.91MB"
 
 	req, _ = http.NewRequest("POST", "/", bytes.NewBuffer([]byte(logLine)))
 	req.URL.RawQuery = "app_name=test"
 
-	listener.ProcessLogs(nil, req)
+	listener.ProcessLogs(httptest.NewRecorder(), req)
 
 	checkDatapoints(dpChan, listener.metricsToExclude, listener.dimensionPairsToExclude, t)
 }
 
+// Creates a listener from conf that reports every second
+func newTestListener(t *testing.T, conf Config) *Listener {
+	conf.IntervalSeconds = 1
+
+	listener, err := NewListener(&conf, make(chan []*datapoint.Datapoint, 1), nil)
+	require.NoError(t, err)
+
+	return listener
+}
+
 func checkDatapoints(dpChan <-chan []*datapoint.Datapoint, metricFilter map[string]bool,
 	dimensionFilter map[string]string, t *testing.T) {
 	timeOut := time.After(1200 * time.Millisecond)
@@ -82,3 +99,363 @@ func shouldExist(dp *datapoint.Datapoint, metricFilter map[string]bool,
 
 	return true
 }
+
+func TestProcessLogsMessageCount(t *testing.T) {
+	listener := newTestListener(t, defaultConfig)
+
+	body := "184 <45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.1 - source=web.1 dyno=heroku.155370883.259625dd-a9c7-4987-9c86-08de28dd4f72 sample#memory_total=99.74MB sample#memory_rss=97.91MB" +
+		"13 not a frame\n"
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(body)))
+	req.URL.RawQuery = "app_name=test"
+	req.Header.Set("Logplex-Msg-Count", "2")
+
+	listener.ProcessLogs(httptest.NewRecorder(), req)
+
+	require.Equal(t, int64(1), listener.totalFrames)
+	require.Equal(t, int64(1), listener.truncatedFrames)
+	require.Equal(t, int64(1), listener.msgCountMismatches)
+	require.Len(t, listener.registry.Datapoints(), 2)
+}
+
+func TestProcessLogsRetries(t *testing.T) {
+	listener := newTestListener(t, defaultConfig)
+
+	body := "139 <190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend"
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(body)))
+		req.URL.RawQuery = "app_name=test"
+		req.Header.Set("Logplex-Drain-Token", "d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f")
+		req.Header.Set("Logplex-Frame-Id", "09C557EAFCFB6CF2740EE62F62971098")
+
+		listener.ProcessLogs(httptest.NewRecorder(), req)
+	}
+
+	require.Equal(t, int64(1), listener.duplicateRequests)
+
+	values := map[string]string{}
+	for _, dp := range listener.registry.Datapoints() {
+		values[dp.Metric] = dp.Value.String()
+	}
+
+	require.Equal(t, map[string]string{"changed_bytes": "5", "response_bytes": "100"}, values)
+}
+
+func TestProcessLogsStatusCodes(t *testing.T) {
+	conf := defaultConfig
+	conf.MaxBodyBytes = 200
+
+	listener := newTestListener(t, conf)
+
+	validBody := "139 <190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend"
+
+	testCases := []struct {
+		body        string
+		query       string
+		contentType string
+		expected    int
+	}{
+		{validBody, "app_name=test", "application/logplex-1", http.StatusNoContent},
+		{validBody, "", "application/logplex-1", http.StatusBadRequest},
+		{validBody, "app_name=test", "application/json", http.StatusUnsupportedMediaType},
+		{validBody + validBody, "app_name=test", "application/logplex-1", http.StatusRequestEntityTooLarge},
+		{"not a frame\n", "app_name=test", "", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(tc.body)))
+		req.URL.RawQuery = tc.query
+		req.Header.Set("Content-Type", tc.contentType)
+
+		w := httptest.NewRecorder()
+		listener.ProcessLogs(w, req)
+
+		require.Equalf(t, tc.expected, w.Code, "Unexpected status code for %v", tc)
+	}
+
+	// Saturate the pipeline
+	listener.inFlight = make(chan struct{}, 1)
+	listener.inFlight <- struct{}{}
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(validBody)))
+	req.URL.RawQuery = "app_name=test"
+
+	w := httptest.NewRecorder()
+	listener.ProcessLogs(w, req)
+
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, int64(1), *listener.responses[http.StatusServiceUnavailable])
+}
+
+func TestProcessLogsAuthentication(t *testing.T) {
+	conf := defaultConfig
+	conf.DrainUsername = "user"
+	conf.DrainPassword = "secret"
+	conf.DrainTokens = map[string]bool{"d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f": true}
+
+	listener := newTestListener(t, conf)
+
+	body := "139 <190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend"
+
+	testCases := []struct {
+		username string
+		password string
+		token    string
+		expected int
+	}{
+		{"user", "secret", "d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f", http.StatusNoContent},
+		{"user", "wrong", "d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f", http.StatusUnauthorized},
+		{"", "", "d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f", http.StatusUnauthorized},
+		{"user", "secret", "d.2c3f5dca-c3d7-4b21-9d24-1e3bd7f3a1d9", http.StatusUnauthorized},
+		{"user", "secret", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(body)))
+		req.URL.RawQuery = "app_name=test"
+		req.Header.Set("Logplex-Drain-Token", tc.token)
+
+		if tc.username != "" {
+			req.SetBasicAuth(tc.username, tc.password)
+		}
+
+		w := httptest.NewRecorder()
+		listener.ProcessLogs(w, req)
+
+		require.Equalf(t, tc.expected, w.Code, "Unexpected status code for %v", tc)
+	}
+}
+
+func TestProcessLogsAppMapping(t *testing.T) {
+	conf := defaultConfig
+	conf.DrainTokenMapping = map[string]map[string]string{
+		"d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f": {"app_name": "mapped-app", "team": "payments"},
+	}
+
+	listener := newTestListener(t, conf)
+
+	body := "139 <190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend"
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(body)))
+	req.Header.Set("Logplex-Drain-Token", "d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f")
+
+	w := httptest.NewRecorder()
+	listener.ProcessLogs(w, req)
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	dps := listener.registry.Datapoints()
+	require.Len(t, dps, 2)
+
+	for _, dp := range dps {
+		require.Equal(t, "mapped-app", dp.Dimensions["app_name"])
+		require.Equal(t, "payments", dp.Dimensions["team"])
+	}
+
+	// Lines of unmapped drains are mapped by their syslog hostname
+	listener.syslogNameDims = map[string]map[string]string{
+		"myhost": {"app_name": "host-app"},
+	}
+
+	body = "141 <190>1 2019-12-21T22:21:26.705132+00:00 myhost app web.1 - counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend" +
+		"139 <190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend"
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewBuffer([]byte(body)))
+
+	w = httptest.NewRecorder()
+	listener.ProcessLogs(w, req)
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, int64(1), listener.unmappedLines)
+
+	for _, dp := range listener.registry.Datapoints() {
+		require.Contains(t, []string{"mapped-app", "host-app"}, dp.Dimensions["app_name"])
+	}
+}
+
+func TestProcessLogsCompressed(t *testing.T) {
+	conf := defaultConfig
+	conf.MaxDecompressedBodyBytes = 1000
+
+	listener := newTestListener(t, conf)
+
+	body := "139 <190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend"
+
+	var gzipped bytes.Buffer
+
+	gw := gzip.NewWriter(&gzipped)
+	_, _ = gw.Write([]byte(body))
+	_ = gw.Close()
+
+	var deflated bytes.Buffer
+
+	zw := zlib.NewWriter(&deflated)
+	_, _ = zw.Write([]byte(body))
+	_ = zw.Close()
+
+	var bomb bytes.Buffer
+
+	bw := gzip.NewWriter(&bomb)
+	_, _ = bw.Write(bytes.Repeat([]byte(body), 100))
+	_ = bw.Close()
+
+	testCases := []struct {
+		body     []byte
+		encoding string
+		expected int
+	}{
+		{gzipped.Bytes(), "gzip", http.StatusNoContent},
+		{deflated.Bytes(), "deflate", http.StatusNoContent},
+		{bomb.Bytes(), "gzip", http.StatusRequestEntityTooLarge},
+		{gzipped.Bytes(), "br", http.StatusUnsupportedMediaType},
+		{[]byte(body), "gzip", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("POST", "/", bytes.NewBuffer(tc.body))
+		req.URL.RawQuery = "app_name=test"
+		req.Header.Set("Content-Encoding", tc.encoding)
+
+		w := httptest.NewRecorder()
+		listener.ProcessLogs(w, req)
+
+		require.Equalf(t, tc.expected, w.Code, "Unexpected status code for %s", tc.encoding)
+	}
+
+	require.Equal(t, int64(2*len(body)), listener.uncompressedBytes)
+}
+
+func TestProcessLineStructuredData(t *testing.T) {
+	conf := defaultConfig
+	conf.StructuredDataDimensions = []string{"env"}
+
+	listener := newTestListener(t, conf)
+
+	require.True(t, listener.processLine(`<190>1 2019-12-21T22:21:26Z myhost app web.1 - [meta env="prod" region="us"] counter#changed_bytes=5`,
+		map[string]string{"app_name": "test"}))
+	require.False(t, listener.processLine("not a syslog message", map[string]string{"app_name": "test"}))
+
+	require.Equal(t, int64(1), listener.unsupportedLines)
+
+	dps := listener.registry.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, "prod", dps[0].Dimensions["env"])
+	require.Empty(t, dps[0].Dimensions["region"])
+}
+
+func TestProcessLineLogTimestamps(t *testing.T) {
+	conf := defaultConfig
+	conf.IntervalSeconds = 10
+	conf.UseLogTimestamps = true
+	conf.LateLineToleranceSeconds = 60
+	conf.FutureLineToleranceSeconds = 10
+
+	listener, err := NewListener(&conf, make(chan []*datapoint.Datapoint, 1), nil)
+	require.NoError(t, err)
+	dims := map[string]string{"app_name": "test"}
+
+	lineAt := func(ts time.Time, message string) string {
+		return "<190>1 " + ts.Format(time.RFC3339Nano) + " host app web.1 - " + message
+	}
+
+	now := time.Now()
+	bucket := now.Add(-30 * time.Second).Truncate(10 * time.Second)
+
+	require.True(t, listener.processLine(lineAt(bucket.Add(time.Second), "counter#requests=1 gauge#queue=3"), dims))
+	require.True(t, listener.processLine(lineAt(bucket.Add(2*time.Second), "counter#requests=2 gauge#queue=5"), dims))
+	require.False(t, listener.processLine(lineAt(now.Add(-2*time.Minute), "counter#requests=1"), dims))
+	require.False(t, listener.processLine(lineAt(now.Add(time.Minute), "counter#requests=1"), dims))
+
+	require.Equal(t, int64(1), listener.lateLines)
+	require.Equal(t, int64(1), listener.futureLines)
+
+	dps := listener.registry.Datapoints()
+	require.Len(t, dps, 2)
+
+	for _, dp := range dps {
+		require.True(t, bucket.Equal(dp.Timestamp), "Unexpected timestamp for %s", dp.Metric)
+
+		switch dp.Metric {
+		case "requests":
+			require.Equal(t, datapoint.NewFloatValue(3), dp.Value)
+		case "queue":
+			require.Equal(t, datapoint.NewFloatValue(5), dp.Value)
+		}
+	}
+
+	// Late lines for a bucket that was already reported update its total
+	require.True(t, listener.processLine(lineAt(bucket.Add(3*time.Second), "counter#requests=4"), dims))
+
+	for _, dp := range listener.registry.Datapoints() {
+		if dp.Metric == "requests" {
+			require.True(t, bucket.Equal(dp.Timestamp))
+			require.Equal(t, datapoint.NewFloatValue(7), dp.Value)
+		}
+	}
+}
+
+func TestProcessLineAddonPlans(t *testing.T) {
+	conf := defaultConfig
+	conf.AddonPlans = map[string]string{"postgresql-curved-12345": "standard-0"}
+
+	listener := newTestListener(t, conf)
+
+	require.True(t, listener.processLine(`<134>1 2020-01-13T18:27:50+00:00 host app heroku-postgres - source=DATABASE addon=postgresql-curved-12345 sample#tables=13`,
+		map[string]string{"app_name": "test"}))
+
+	dps := listener.registry.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, "heroku.postgres.tables", dps[0].Metric)
+	require.Equal(t, "standard-0", dps[0].Dimensions["plan"])
+}
+
+func TestProcessLineDynoStates(t *testing.T) {
+	listener := newTestListener(t, defaultConfig)
+	dims := map[string]string{"app_name": "test"}
+
+	require.True(t, listener.processLine(`<45>1 2019-12-11T22:29:21+00:00 host heroku web.1 - State changed from starting to up`, dims))
+	require.True(t, listener.processLine(`<45>1 2019-12-11T22:29:21+00:00 host heroku web.2 - State changed from starting to up`, dims))
+	require.True(t, listener.processLine(`<45>1 2019-12-11T22:29:22+00:00 host heroku web.2 - State changed from up to crashed`, dims))
+
+	listener.dynos.updateRegistry(listener.registry)
+
+	var dynosUp []*datapoint.Datapoint
+	for _, dp := range listener.registry.Datapoints() {
+		if dp.Metric == "heroku.dynos_up" {
+			dynosUp = append(dynosUp, dp)
+		}
+	}
+
+	require.Len(t, dynosUp, 1)
+	require.Equal(t, datapoint.NewFloatValue(1), dynosUp[0].Value)
+	require.Equal(t, map[string]string{"app_name": "test", "process_type": "web"}, dynosUp[0].Dimensions)
+}
+
+func TestNewListenerInvalidLogRules(t *testing.T) {
+	conf := defaultConfig
+	conf.LogRules = []LogRule{{Metric: "checkouts", Type: "counter", Pattern: `checkout (`}}
+
+	_, err := NewListener(&conf, make(chan []*datapoint.Datapoint, 1), nil)
+	require.Error(t, err)
+}
+
+func TestProcessLineJSONMapping(t *testing.T) {
+	conf := defaultConfig
+	conf.JSONMapping = &JSONMapping{
+		Metrics:    map[string]string{"duration": "gauge"},
+		Dimensions: map[string]string{"request.route": "route", "app": "app_name"},
+	}
+
+	listener := newTestListener(t, conf)
+
+	// Neither mapped fields nor sfxdimension# keys can override the app name
+	require.True(t, listener.processLine(`<190>1 2020-01-13T18:27:50+00:00 host app web.1 - {"duration": 12, "request": {"route": "/orders"}, "app": "other-app", "sfxdimension#app_name": "other-app"}`,
+		map[string]string{"app_name": "test"}))
+
+	dps := listener.registry.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, "duration", dps[0].Metric)
+	require.Equal(t, "/orders", dps[0].Dimensions["route"])
+	require.Equal(t, "test", dps[0].Dimensions["app_name"])
+}
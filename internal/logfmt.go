@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"strings"
+)
+
+type logfmtPair struct {
+	Key   string
+	Value string
+}
+
+// Splits a message in the logfmt format into key/value pairs, in the order
+// they appear in. Values can be double-quoted, in which case they can contain
+// spaces and quotes escaped with a backslash. Unquoted values extend up to
+// the next space and can contain "=". Words that are not followed by "="
+// are not key/value pairs and are skipped. For more information, see:
+// https://brandur.org/logfmt
+func parseLogfmt(message string) []logfmtPair {
+	var pairs []logfmtPair
+
+	i := 0
+	for i < len(message) {
+		if message[i] == ' ' || message[i] == '\t' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(message) && message[i] > ' ' && message[i] != '=' && message[i] != '"' {
+			i++
+		}
+
+		key := message[start:i]
+
+		if key == "" || i >= len(message) || message[i] != '=' {
+			i = skipWord(message, i)
+			continue
+		}
+
+		// Skip the "="
+		i++
+
+		var value string
+		if i < len(message) && message[i] == '"' {
+			value, i = readQuotedValue(message, i+1)
+		} else {
+			start = i
+			i = skipWord(message, i)
+			value = message[start:i]
+		}
+
+		pairs = append(pairs, logfmtPair{Key: key, Value: value})
+	}
+
+	return pairs
+}
+
+// Returns the index of the next space at or after i
+func skipWord(message string, i int) int {
+	for i < len(message) && message[i] != ' ' && message[i] != '\t' {
+		if message[i] == '"' {
+			_, i = readQuotedValue(message, i+1)
+			continue
+		}
+
+		i++
+	}
+
+	return i
+}
+
+// Reads a quoted value starting right after the opening quote. Returns the
+// unescaped value and the index right after the closing quote. An
+// unterminated value extends to the end of the message.
+func readQuotedValue(message string, i int) (string, int) {
+	var value strings.Builder
+
+	for ; i < len(message); i++ {
+		switch message[i] {
+		case '"':
+			return value.String(), i + 1
+		case '\\':
+			if i+1 < len(message) {
+				i++
+
+				switch message[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 't':
+					value.WriteByte('\t')
+				case 'r':
+					value.WriteByte('\r')
+				case '"', '\\':
+					value.WriteByte(message[i])
+				default:
+					value.WriteByte('\\')
+					value.WriteByte(message[i])
+				}
+
+				continue
+			}
+		}
+
+		value.WriteByte(message[i])
+	}
+
+	return value.String(), i
+}
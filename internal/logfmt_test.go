@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	testCases := []struct {
+		message  string
+		expected []logfmtPair
+	}{
+		{
+			message: `at=error code=H12 desc="Request timeout" path=/search?q=a=b connect= status=503`,
+			expected: []logfmtPair{
+				{Key: "at", Value: "error"},
+				{Key: "code", Value: "H12"},
+				{Key: "desc", Value: "Request timeout"},
+				{Key: "path", Value: "/search?q=a=b"},
+				{Key: "connect", Value: ""},
+				{Key: "status", Value: "503"},
+			},
+		},
+		{
+			message: `sfxdimension#team="data \"platform\"" sfxdimension#query="a=1 b=2"  gauge#queue=3`,
+			expected: []logfmtPair{
+				{Key: "sfxdimension#team", Value: `data "platform"`},
+				{Key: "sfxdimension#query", Value: "a=1 b=2"},
+				{Key: "gauge#queue", Value: "3"},
+			},
+		},
+		{
+			message: `Starting process with command "bundle exec puma -p 5000" state=up`,
+			expected: []logfmtPair{
+				{Key: "state", Value: "up"},
+			},
+		},
+		{
+			message: `msg="unterminated \\ value`,
+			expected: []logfmtPair{
+				{Key: "msg", Value: `unterminated \ value`},
+			},
+		},
+		{
+			message:  `no pairs here`,
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		require.Equalf(t, tc.expected, parseLogfmt(tc.message), "Unexpected pairs for %s", tc.message)
+	}
+}
+
+func TestMessagePairsTokenizedOnce(t *testing.T) {
+	ll := &logLine{Message: `at=info method=GET path="/orders?page=2" status=200`}
+
+	require.Equal(t, "info", ll.messageValue("at"))
+	require.Equal(t, "/orders?page=2", ll.messageValue("path"))
+	require.Equal(t, "", ll.messageValue("missing"))
+
+	// The pairs are reused rather than tokenized again
+	ll.Message = "at=error"
+	require.Equal(t, "info", ll.messageValue("at"))
+}
@@ -15,10 +15,9 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// Based on docs here: https://tools.ietf.org/html/rfc5424#section-6
-// and discussion here: https://stackoverflow.com/questions/25163830/explain-format-of-heroku-logs
-var rfc5424LogFormat = regexp.MustCompile(`\<(?P<pri>\d+)\>(?P<version>1) (?P<timestamp>\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d{6})?\+\d{2}:\d{2}) (?P<hostname>[a-z0-9\-\_\.]+) (?P<appname>[a-z0-9\.-]+) (?P<procid>[a-z0-9\-\_\.]+) (?P<msgid>\-) (?P<message>.*)$`)
-var regexGroups = rfc5424LogFormat.SubexpNames()
+// Based on docs here: https://tools.ietf.org/html/rfc3164#section-4.1
+// The TAG field is used as the appname and the optional PID following it as the procid
+var rfc3164LogFormat = regexp.MustCompile(`^\<(?P<pri>\d{1,3})\>(?P<timestamp>[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (?P<hostname>\S+) (?P<appname>[^\s\[:]+)(?:\[(?P<procid>[^\]]+)\])?: ?(?P<message>.*)$`)
 
 type logLine struct {
 	PRI       string `json:"pri"`
@@ -27,13 +26,75 @@ type logLine struct {
 	Hostname  string `json:"hostname"`
 	Appname   string `json:"appname"`
 	ProcID    string `json:"procid"`
+	MsgID     string `json:"msgid"`
 	Message   string `json:"message"`
+	// Parameters by SD-ID
+	StructuredData map[string]map[string]string `json:"-"`
+
+	// The message decoded as a JSON object, see messageJSON
+	jsonMessage       map[string]interface{}
+	jsonMessageParsed bool
+	// The key/value pairs of the message, see messagePairs
+	pairs       []logfmtPair
+	pairsParsed bool
+}
+
+// Returns the message decoded as a JSON object, or nil if it is not a JSON
+// object. The message is only decoded once per line.
+func (ll *logLine) messageJSON() map[string]interface{} {
+	if !ll.jsonMessageParsed {
+		ll.jsonMessage = parseJSONMessage(ll.Message)
+		ll.jsonMessageParsed = true
+	}
+
+	return ll.jsonMessage
+}
+
+// Returns the key/value pairs of the message, from the top level fields of
+// JSON messages or the logfmt format otherwise. The message is only
+// tokenized once per line.
+func (ll *logLine) messagePairs() []logfmtPair {
+	if !ll.pairsParsed {
+		if obj := ll.messageJSON(); obj != nil {
+			ll.pairs = jsonPairs(obj)
+		} else {
+			ll.pairs = parseLogfmt(ll.Message)
+		}
+
+		ll.pairsParsed = true
+	}
+
+	return ll.pairs
+}
+
+// Returns the value of the first pair of the message with the given key, or
+// an empty string if there is none
+func (ll *logLine) messageValue(key string) string {
+	for _, pair := range ll.messagePairs() {
+		if pair.Key == key {
+			return pair.Value
+		}
+	}
+
+	return ""
 }
 
 // Format based on docs here,
 // https://devcenter.heroku.com/articles/platform-api-reference#custom-types
 var herokuObjectIDFormat = regexp.MustCompile(`^[0-9a-fA-F]{8}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{12}$`)
 
+// Platform errors logged by Heroku, i.e. "Error R14 (Memory quota exceeded)".
+// For more information, see: https://devcenter.heroku.com/articles/error-codes
+var platformErrorFormat = regexp.MustCompile(`^Error ([A-Z][0-9]{2})\b`)
+
+// Dyno lifecycle events logged by Heroku. For more information, see:
+// https://devcenter.heroku.com/articles/dynos#dyno-manager
+var (
+	dynoStateChangeFormat = regexp.MustCompile(`^State changed from (\S+) to (\S+)`)
+	dynoExitFormat        = regexp.MustCompile(`^Process exited with status (\d+)`)
+	dynoStopFormat        = regexp.MustCompile(`^Stopping (?:all processes|process) with (SIG[A-Z0-9]+)`)
+)
+
 // These fields are based on Heroku docs. For more information, see here:
 // https://devcenter.heroku.com/articles/http-routing#heroku-router-log-format
 var routerDimensionKeys = makeStringSet("status", "method", "dyno", "protocol", "host", "code")
@@ -45,6 +106,12 @@ var herokuDimensionKeys = mergeBoolMaps(dynoDimensionKeys, routerDimensionKeys)
 // Also note that all the 3 strings stated here are all from router logs
 var herokuMetricKeys = makeStringSet("connect", "service", "bytes")
 
+// Heroku Redis metrics that count events since the previous sample
+var redisCountMetrics = makeStringSet("heroku.redis.evicted_keys")
+
+// Router timings are also recorded as histograms, to report their percentiles
+var routerHistogramKeys = makeStringSet("connect", "service")
+
 // In some cases metricVal names derived from the logs don't make a lot of sense.
 // Have an alternative name for such metrics
 var refinedRouterMetricNames = map[string]string{
@@ -53,7 +120,10 @@ var refinedRouterMetricNames = map[string]string{
 	"bytes":   "heroku.router_response_bytes",
 }
 
-func getDimensionPairsFromParams(values url.Values) (map[string]string, error) {
+// Returns dimensions to add to all datapoints from a drain request. Dimensions
+// mapped from the drain token take precedence over query parameters, so
+// that drains can be added without any parameters when a mapping exists.
+func getDimensionPairsFromParams(values url.Values, mappedDims map[string]string) map[string]string {
 	dims := map[string]string{}
 
 	for dimKey, dimValue := range values {
@@ -63,18 +133,23 @@ func getDimensionPairsFromParams(values url.Values) (map[string]string, error) {
 		}
 	}
 
-	if dims["app_name"] == "" {
-		return nil, fmt.Errorf("app_name parameter takes exactly one value. current value: %v", values["app_name"])
-	}
-
-	return dims, nil
+	return mergeStringMaps(dims, mappedDims)
 }
 
 // Returns a logLine struct if the line matches a supported format
 func detectAndParseLog(line string) (*logLine, error) {
-	match := rfc5424LogFormat.FindStringSubmatch(line)
+	if result := parseRFC5424(line); result != nil {
+		return result, nil
+	}
+
+	// Simply ignore logs that not match any of the supported formats
+	return parseRFC3164(line)
+}
+
+func parseRFC3164(line string) (*logLine, error) {
+	match := rfc3164LogFormat.FindStringSubmatch(line)
+	regexGroups := rfc3164LogFormat.SubexpNames()
 
-	// Simply ignore logs that not match the specified format
 	if len(match) != len(regexGroups) {
 		return nil, nil
 	}
@@ -94,14 +169,19 @@ func detectAndParseLog(line string) (*logLine, error) {
 		return nil, err
 	}
 
+	// The PID is optional
+	if result.ProcID == "" {
+		result.ProcID = nilValue
+	}
+
 	return &result, nil
 }
 
 // Returns datapoints processed from a logLine struct. Specifically, this
 // method processes the message field in it. This method assumes that the
 // message has information about dimensions and metrics, always in the
-// following form and this is the only part of the message that's processed
-// "key1=value1 key2=value2 key3=value3 sample#metric_name=metric_value"
+// logfmt form and this is the only part of the message that's processed
+// "key1=value1 key2="value 2" key3=value3 sample#metric_name=metric_value"
 func processMetrics(ll *logLine, dimsFromParmas map[string]string) ([]*registry.MetricVal, map[string]string) {
 	// To match dyno numbers from dyno names. Dyno names the following format
 	// "web.45", "run.9123", "worker.2" where the prefix denotes the type of process
@@ -111,27 +191,180 @@ func processMetrics(ll *logLine, dimsFromParmas map[string]string) ([]*registry.
 
 	metrics, dims := ll.evaluateKeyValuePairs()
 
+	if code := ll.platformErrorCode(); code != "" {
+		metrics = append(metrics, platformError(code, nil))
+	}
+
+	if metric := ll.dynoEvent(); metric != nil {
+		metrics = append(metrics, metric)
+	}
+
 	// dimensions from parameters will take precedence over dimensions from logs
 	// in case there are duplicate keys
-	dims = mergeStringMaps(dimsFromParmas, dims)
+	dims = mergeStringMaps(dims, dimsFromParmas)
 
 	switch processType {
+	case "heroku-postgres":
+		metrics, dims = fixUpAddonMetrics(metrics, dims, ll.messageValue("addon"), "heroku.postgres.", "database_attachment")
+	case "heroku-redis":
+		metrics, dims = fixUpRedisMetrics(metrics, dims, ll.messageValue("addon"))
 	case "router":
-		metrics, dims = fixUpRouterMetrics(metrics, dims)
+		metrics, dims = fixUpRouterMetrics(metrics, dims, ll.messageValue("at"))
 	default:
-		metrics, dims = fixUpDynoMetrics(metrics, dims, processType)
+		metrics, dims = fixUpDynoMetrics(metrics, dims, ll.ProcID, processType)
 	}
 
 	return metrics, dims
 }
 
-// Cleanup router metric names
-func fixUpRouterMetrics(metrics []*registry.MetricVal, dims map[string]string) ([]*registry.MetricVal, map[string]string) {
+// Cleanup router metric names, record timings as histograms on top of the
+// cumulative counters, and count the request. The request count
+// has its own "status_class" (i.e. "5xx") and "at" (either "info" or
+// "error") dimensions so that error rates can be computed from it, on top
+// of the "status" and "code" (i.e. "H12") ones of all router metrics.
+func fixUpRouterMetrics(metrics []*registry.MetricVal, dims map[string]string, at string) ([]*registry.MetricVal, map[string]string) {
+	out := make([]*registry.MetricVal, 0, len(metrics)+len(routerHistogramKeys)+1)
+
 	for i := range metrics {
-		if refinedRouterMetricNames[metrics[i].Name] != "" {
-			metrics[i].Name = refinedRouterMetricNames[metrics[i].Name]
+		name := metrics[i].Name
+
+		if refinedRouterMetricNames[name] != "" {
+			metrics[i].Name = refinedRouterMetricNames[name]
 			metrics[i].Type = datapoint.Counter
 		}
+
+		out = append(out, metrics[i])
+
+		if routerHistogramKeys[name] {
+			out = append(out, &registry.MetricVal{
+				Name:  metrics[i].Name,
+				Type:  registry.Histogram,
+				Value: metrics[i].Value,
+			})
+		}
+	}
+
+	requestDims := map[string]string{}
+	if at != "" {
+		requestDims["at"] = at
+	}
+
+	if status := dims["status"]; len(status) == 3 && status[0] >= '1' && status[0] <= '5' {
+		requestDims["status_class"] = status[:1] + "xx"
+	}
+
+	// Router errors are platform errors as well, i.e. H12 (Request timeout)
+	if at == "error" && dims["code"] != "" {
+		errorDims := map[string]string{}
+		if dims["dyno"] != "" {
+			errorDims["process_type"] = strings.Split(dims["dyno"], ".")[0]
+		}
+
+		out = append(out, platformError("", errorDims))
+	}
+
+	out = append(out, &registry.MetricVal{
+		Name:       "heroku.router_requests",
+		Type:       datapoint.Count,
+		Value:      1,
+		Dimensions: requestDims,
+	})
+
+	return out, dims
+}
+
+// Returns the code of the platform error in a line logged by Heroku, if any
+func (ll *logLine) platformErrorCode() string {
+	if ll.Appname != "heroku" {
+		return ""
+	}
+
+	match := platformErrorFormat.FindStringSubmatch(ll.Message)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// Returns a count of the dyno lifecycle event in a line logged by Heroku for
+// a dyno, if any
+func (ll *logLine) dynoEvent() *registry.MetricVal {
+	if ll.Appname != "heroku" || ll.ProcID == "router" {
+		return nil
+	}
+
+	event := &registry.MetricVal{
+		Type:  datapoint.Count,
+		Value: 1,
+	}
+
+	if match := dynoStateChangeFormat.FindStringSubmatch(ll.Message); match != nil {
+		event.Name = dynoStateChangesMetric
+		event.Dimensions = map[string]string{"from": match[1], "to": match[2]}
+	} else if match := dynoExitFormat.FindStringSubmatch(ll.Message); match != nil {
+		event.Name = "heroku.dyno_exits"
+		event.Dimensions = map[string]string{"exit_status": match[1]}
+	} else if match := dynoStopFormat.FindStringSubmatch(ll.Message); match != nil {
+		event.Name = "heroku.dyno_stops"
+		event.Dimensions = map[string]string{"signal": match[1]}
+	} else {
+		return nil
+	}
+
+	return event
+}
+
+// Counts a platform error. The code dimension is left out when empty, for
+// it to come from the line, as in router lines.
+func platformError(code string, dims map[string]string) *registry.MetricVal {
+	if code != "" {
+		dims = mergeStringMaps(dims, map[string]string{"code": code})
+	}
+
+	return &registry.MetricVal{
+		Name:       "heroku.platform_errors",
+		Type:       datapoint.Count,
+		Value:      1,
+		Dimensions: dims,
+	}
+}
+
+// Heroku add-ons log metrics from their own procid, along with the name of
+// the add-on and the name it's attached to the app with, i.e.
+// "source=DATABASE addon=postgresql-curved-12345 sample#active-connections=2".
+// Metric names are prefixed with the add-on type instead of "heroku." and
+// use underscores instead of hyphens, as the other Heroku metrics. The
+// source, which is the attachment name, is reported under attachmentDim.
+func fixUpAddonMetrics(metrics []*registry.MetricVal, dims map[string]string, addon string, prefix string, attachmentDim string) ([]*registry.MetricVal, map[string]string) {
+	for i := range metrics {
+		if strings.HasPrefix(metrics[i].Name, "heroku.") {
+			metrics[i].Name = prefix + strings.Replace(strings.TrimPrefix(metrics[i].Name, "heroku."), "-", "_", -1)
+		}
+	}
+
+	if dims["source"] != "" {
+		dims[attachmentDim] = dims["source"]
+		delete(dims, "source")
+	}
+
+	if addon != "" {
+		dims["addon"] = addon
+	}
+
+	return metrics, dims
+}
+
+// Heroku Redis metrics are mostly gauges, except for the ones counting
+// events, which are reported as counters. For more information, see:
+// https://devcenter.heroku.com/articles/heroku-redis-metrics-logs
+func fixUpRedisMetrics(metrics []*registry.MetricVal, dims map[string]string, addon string) ([]*registry.MetricVal, map[string]string) {
+	metrics, dims = fixUpAddonMetrics(metrics, dims, addon, "heroku.redis.", "attachment")
+
+	for i := range metrics {
+		if redisCountMetrics[metrics[i].Name] {
+			metrics[i].Type = datapoint.Count
+		}
 	}
 
 	return metrics, dims
@@ -140,10 +373,10 @@ func fixUpRouterMetrics(metrics []*registry.MetricVal, dims map[string]string) (
 // Handle post processing of metrics and dims collected. More specifically,
 // (1) add "process_type" dimension which has the value set to the process
 // with which the dyno is initialized. (2) derive "dyno_id" dimension from
-// existing "dyno" field collected. (3) add "dyno" dimension with the same
-// value as source. This will make it easy to filter both router and dyno
-// metrics by a single dimension
-func fixUpDynoMetrics(metrics []*registry.MetricVal, dims map[string]string, processType string) ([]*registry.MetricVal, map[string]string) {
+// existing "dyno" field collected. (3) add "dyno" dimension with the dyno
+// name from the procid. This will make it easy to filter both router and
+// dyno metrics by a single dimension
+func fixUpDynoMetrics(metrics []*registry.MetricVal, dims map[string]string, procID string, processType string) ([]*registry.MetricVal, map[string]string) {
 	dims["process_type"] = processType
 	if dims["dyno"] != "" {
 		// expects values of this form: "heroku.155370883.259625dd-a9c7-4987-9c86-08de28dd4f72"
@@ -160,38 +393,95 @@ func fixUpDynoMetrics(metrics []*registry.MetricVal, dims map[string]string, pro
 	}
 
 	// add dyno name as a dimension
-	if dims["source"] != "" {
-		dims["dyno"] = dims["source"]
+	if procID != "" {
+		dims["dyno"] = procID
 	}
 
-	return metrics, dims
+	return append(metrics, memoryPercentMetrics(metrics)...), dims
+}
+
+// Derives the memory utilization and swap usage of a dyno, as a percentage of
+// its memory quota, from the memory metrics logged in the same line
+func memoryPercentMetrics(metrics []*registry.MetricVal) []*registry.MetricVal {
+	values := map[string]float64{}
+	for _, metric := range metrics {
+		values[metric.Name] = metric.Value
+	}
+
+	quota := values["heroku.memory_quota"]
+	if quota <= 0 {
+		return nil
+	}
+
+	var out []*registry.MetricVal
+
+	if total, ok := values["heroku.memory_total"]; ok {
+		out = append(out, &registry.MetricVal{
+			Name:  "heroku.memory_utilization_percent",
+			Type:  datapoint.Gauge,
+			Value: total / quota * 100,
+		})
+	}
+
+	if swap, ok := values["heroku.memory_swap"]; ok {
+		out = append(out, &registry.MetricVal{
+			Name:  "heroku.memory_swap_percent",
+			Type:  datapoint.Gauge,
+			Value: swap / quota * 100,
+		})
+	}
+
+	return out
 }
 
-// Gets metrics and dimensions from the message field on a log line. Note that this
+// Gets metrics and dimensions from the message field on a log line, which is
+// either in the logfmt format, a JSON object or a StatsD line. Note that this
 // method adds "source" dimensions by default on all  metrics
 func (ll *logLine) evaluateKeyValuePairs() ([]*registry.MetricVal, map[string]string) {
 	metrics := make([]*registry.MetricVal, 0)
 	dims := map[string]string{"source": ll.ProcID}
 
-	for _, pair := range strings.Split(ll.Message, " ") {
-		splitPair := strings.Split(pair, "=")
+	// Messages that only look like StatsD lines are parsed as key/value
+	// pairs instead
+	metric, tags, err := parseStatsD(ll.Message)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"debug":   err,
+			"message": ll.Message,
+		}).Debug("Error making metricVal from StatsD line in log message. Will be parsed as key/value pairs.")
+	}
 
-		// only bother about key/value pairs separated by =
-		if len(splitPair) != 2 {
-			continue
-		}
+	if metric != nil {
+		return append(metrics, metric), mergeStringMaps(dims, tags)
+	}
 
+	for _, pair := range ll.messagePairs() {
 		log.WithFields(log.Fields{
-			"key/value pair": pair,
+			"key":   pair.Key,
+			"value": pair.Value,
 		}).Debug("Processing key/value pair in log message")
 
-		if isMetric(splitPair[0], herokuMetricKeys) {
-			metric, err := evaluateMetric(splitPair)
+		// The members of unique metrics are not numeric
+		if isUnique(pair.Key) {
+			if pair.Value != "" {
+				metrics = append(metrics, &registry.MetricVal{
+					Name:   strings.Replace(pair.Key, "unique#", "", 1),
+					Type:   registry.Unique,
+					Member: pair.Value,
+				})
+			}
+
+			continue
+		}
+
+		if isMetric(pair.Key, herokuMetricKeys) {
+			metric, err := evaluateMetric(pair.Key, pair.Value)
 
 			if err != nil {
 				log.WithFields(log.Fields{
-					"debug":          err,
-					"key-value pair": pair,
+					"debug": err,
+					"key":   pair.Key,
+					"value": pair.Value,
 				}).Debug("Error making metricVal from key/value pair in log message. Will be dropped.")
 
 				continue
@@ -202,10 +492,20 @@ func (ll *logLine) evaluateKeyValuePairs() ([]*registry.MetricVal, map[string]st
 			continue
 		}
 
+		// Following the l2met convention, source is the origin of the
+		// measurements in the line, which defaults to the procid
+		if pair.Key == "source" {
+			if pair.Value != "" {
+				dims["source"] = pair.Value
+			}
+
+			continue
+		}
+
 		// Dimensions for custom metrics
-		if isDimension(splitPair[0], herokuDimensionKeys) {
+		if isDimension(pair.Key, herokuDimensionKeys) {
 			dims = mergeStringMaps(dims, map[string]string{
-				strings.Replace(splitPair[0], "sfxdimension#", "", 1): splitPair[1],
+				strings.Replace(pair.Key, "sfxdimension#", "", 1): pair.Value,
 			})
 		}
 	}
@@ -214,22 +514,30 @@ func (ll *logLine) evaluateKeyValuePairs() ([]*registry.MetricVal, map[string]st
 }
 
 // Evaluates metrics in the message of a log line. This method assumes the
-// input will always be of the following form ["sample#metric_name", "metric_value"]
-// where "metric_value" may or may not include units
-func evaluateMetric(splitPair []string) (*registry.MetricVal, error) {
-	val, err := getNumericValue(splitPair[1])
+// key will always be of the following form "sample#metric_name", and the
+// value will be the metric value, which may or may not include units
+func evaluateMetric(key string, value string) (*registry.MetricVal, error) {
+	val, err := getNumericValue(value)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return getMetric(splitPair[0], *val)
+	return getMetric(key, *val)
 }
 
 // Returns a value stripping out the units for supported units.
 // If an unsupported unit is encountered, the metric will be dropped.
 func getNumericValue(value string) (*float64, error) {
 	out := new(float64)
+
+	// Values without units are parsed as is, since sizes in bytes are
+	// rounded down to integers, i.e. load-avg-1m=0.315
+	if numericValue, err := strconv.ParseFloat(value, 64); err == nil {
+		*out = numericValue
+		return out, nil
+	}
+
 	// If the units pertain to memory, get size in bytes
 	bytes, err := units.RAMInBytes(value)
 
@@ -246,8 +554,10 @@ func getNumericValue(value string) (*float64, error) {
 	}
 
 	// Check for units called "pages" from memory_pgpgin and memory_pgpgout
-	// which are standard metrics in Heroku
+	// which are standard metrics in Heroku, and "bytes" from add-on metrics,
+	// i.e. db_size in Heroku Postgres
 	value = strings.Replace(value, "pages", "", 1)
+	value = strings.TrimSuffix(value, "bytes")
 
 	numericValue, err := strconv.ParseFloat(value, 64)
 
@@ -281,6 +591,18 @@ func processRawMetricKey(rawMetricName string) (string, datapoint.MetricType) {
 		return strings.Replace(rawMetricName, "cumulative#", "", 1), datapoint.Counter
 	}
 
+	if isHistogram(rawMetricName) {
+		return strings.Replace(rawMetricName, "histogram#", "", 1), registry.Histogram
+	}
+
+	if isMeasure(rawMetricName) {
+		return strings.Replace(rawMetricName, "measure#", "", 1), registry.Measure
+	}
+
+	if isCount(rawMetricName) {
+		return strings.Replace(rawMetricName, "count#", "", 1), datapoint.Count
+	}
+
 	// Standard Heroku metrics log run-time metrics identified as samples in the log message.
 	// Remove the sample# prefix from such metric names, and also add a "heroku" prefix to
 	// make metrics easily searchable
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,6 +31,7 @@ func TestProcessLogs(t *testing.T) {
 			Hostname:  "host",
 			Appname:   "heroku",
 			ProcID:    "router",
+			MsgID:     "-",
 			Message:   "at=error code=H12 desc=\"Request timeout\" method=GET path=/ host=myapp.herokuapp.com request_id=8601b555-6a83-4c12-8269-97c8e32cdb22 fwd=\"204.204.204.204\" dyno=web.1 connect= service=30000ms status=503 bytes=0 protocol=http",
 		}, {
 			PRI:       "158",
@@ -38,6 +40,7 @@ func TestProcessLogs(t *testing.T) {
 			Hostname:  "host",
 			Appname:   "heroku",
 			ProcID:    "router",
+			MsgID:     "-",
 			Message:   "at=info method=GET path=\"/test\" host=aqueous-oasis-14017.herokuapp.com request_id=93bf8b6c-34b1-4eb8-9b5b-f0e72e5ce377 fwd=\"76.195.93.225\" dyno=web.1 connect=0ms service=1ms status=404 bytes=146 protocol=https",
 		}, {
 			PRI:       "45",
@@ -46,6 +49,7 @@ func TestProcessLogs(t *testing.T) {
 			Hostname:  "host",
 			Appname:   "heroku",
 			ProcID:    "web.1",
+			MsgID:     "-",
 			Message:   "source=web.1 dyno=heroku.155370883.259625dd-a9c7-4987-9c86-08de28dd4f72 sample#memory_total=99.74MB sample#memory_rss=97.91MB sample#memory_cache=1.83MB sample#memory_swap=0.00MB sample#memory_pgpgin=355603pages sample#memory_pgpgout=333646pages sample#memory_quota=512.00MB",
 		}, {
 			PRI:       "45",
@@ -54,6 +58,7 @@ func TestProcessLogs(t *testing.T) {
 			Hostname:  "host",
 			Appname:   "heroku",
 			ProcID:    "web.2",
+			MsgID:     "-",
 			Message:   "source=web.2 dyno=heroku.155370883.e764d0ed-b239-4048-9caa-38a78dfeb6d0 sample#load_avg_1m=0.00",
 		}, {
 			PRI:       "190",
@@ -62,6 +67,7 @@ func TestProcessLogs(t *testing.T) {
 			Hostname:  "host",
 			Appname:   "app",
 			ProcID:    "web.1",
+			MsgID:     "-",
 			Message:   "gauge#quota_used=20 counter#changed_bytes=5 cumulative#response_bytes=100 sfxdimension#service=backend sfxdimension#client=sfx_app",
 		},
 	}
@@ -70,12 +76,18 @@ func TestProcessLogs(t *testing.T) {
 	expectedTypes := [][]datapoint.MetricType{
 		{
 			datapoint.Counter,
+			registry.Histogram,
 			datapoint.Counter,
+			datapoint.Count,
+			datapoint.Count,
 		},
 		{
 			datapoint.Counter,
+			registry.Histogram,
 			datapoint.Counter,
+			registry.Histogram,
 			datapoint.Counter,
+			datapoint.Count,
 		},
 		{
 			datapoint.Gauge,
@@ -85,6 +97,8 @@ func TestProcessLogs(t *testing.T) {
 			datapoint.Gauge,
 			datapoint.Gauge,
 			datapoint.Gauge,
+			datapoint.Gauge,
+			datapoint.Gauge,
 		},
 		{
 			datapoint.Gauge,
@@ -99,9 +113,9 @@ func TestProcessLogs(t *testing.T) {
 	for i, input := range validInputs {
 		actual, _ := detectAndParseLog(input)
 
-		if *expectedParsedLog[i] != *actual {
-			t.Logf("Expected: %s", *expectedParsedLog[i])
-			t.Logf("Actual: %s", *actual)
+		if !reflect.DeepEqual(expectedParsedLog[i], actual) {
+			t.Logf("Expected: %+v", *expectedParsedLog[i])
+			t.Logf("Actual: %+v", *actual)
 			t.Error("Parsed log output does not match expected")
 		}
 
@@ -125,29 +139,322 @@ func TestProcessLogs(t *testing.T) {
 	}
 }
 
-func TestGetDimensionPairsFromParams(t *testing.T) {
-	values := url.Values{
-		"dim1": []string{"val1", "val2"},
-		"dim2": []string{"val1"},
+func TestDetectAndParseRFC5424Log(t *testing.T) {
+	actual, err := detectAndParseLog(`<165>1 2003-10-11T22:14:15.003Z MYHOST.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`)
+	require.NoError(t, err)
+	require.Equal(t, &logLine{
+		PRI:            "165",
+		Version:        "1",
+		Timestamp:      "2003-10-11T22:14:15.003Z",
+		Hostname:       "MYHOST.example.com",
+		Appname:        "evntslog",
+		ProcID:         "-",
+		MsgID:          "ID47",
+		Message:        "An application event",
+		StructuredData: map[string]map[string]string{"exampleSDID@32473": {"iut": "3", "eventSource": "Application"}},
+	}, actual)
+
+	validInputs := []string{
+		"<34>1 2003-08-24T05:14:15.000000003-07:00 myhost app web.1 - - counter#jobs=1",
+		"<34>1 2003-08-24T05:14:15.1+05:30 host app web.1 - counter#jobs=1",
+		"<34>1 - host app web.1 - counter#jobs=1",
+	}
+
+	for _, input := range validInputs {
+		actual, err := detectAndParseLog(input)
+		require.NoError(t, err)
+		require.NotNilf(t, actual, "Expected %q to be parsed", input)
+		require.Equal(t, "counter#jobs=1", actual.Message)
 	}
 
-	_, err := getDimensionPairsFromParams(values)
+	invalidInputs := []string{
+		"<34>1 2003-08-24 05:14:15 host app web.1 - counter#jobs=1",
+		"<34>0 2003-08-24T05:14:15Z host app web.1 - counter#jobs=1",
+		"<192>1 2003-08-24T05:14:15Z host app web.1 - counter#jobs=1",
+		"<-1>1 2003-08-24T05:14:15Z host app web.1 - counter#jobs=1",
+		"<+9>1 2003-08-24T05:14:15Z host app web.1 - counter#jobs=1",
+		"<34>1 2003-08-24T05:14:15Z host app",
+		"counter#jobs=1",
+	}
 
-	// Expected to error out since "app_name" is not passed in
-	if err == nil {
-		t.Errorf("Expected to fail since app_name is not passed in")
+	for _, input := range invalidInputs {
+		actual, err := detectAndParseLog(input)
+		require.NoError(t, err)
+		require.Nilf(t, actual, "Expected %q to not be parsed", input)
+	}
+}
+
+// Logplex frames have no STRUCTURED-DATA, so messages starting like it are
+// kept as is
+func TestDetectAndParseLogplexMessagePrefixes(t *testing.T) {
+	for _, message := range []string{
+		"- GET /orders 200",
+		"-",
+		"[user@example.com] logged in",
+		"[INFO] Listening on port 8000",
+		`[meta app_name="other-app"] counter#changed_bytes=5`,
+	} {
+		for _, hostname := range []string{"host", "d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f"} {
+			actual, err := detectAndParseLog("<190>1 2019-12-21T22:21:26.705132+00:00 " + hostname + " app web.1 - " + message)
+			require.NoError(t, err)
+			require.Equal(t, message, actual.Message)
+			require.Nil(t, actual.StructuredData)
+		}
+	}
+}
+
+func TestDetectAndParseRFC3164Log(t *testing.T) {
+	actual, err := detectAndParseLog("<13>Oct  1 22:14:15 myhost worker[1234]: counter#jobs=1")
+	require.NoError(t, err)
+	require.Equal(t, &logLine{
+		PRI:       "13",
+		Timestamp: "Oct  1 22:14:15",
+		Hostname:  "myhost",
+		Appname:   "worker",
+		ProcID:    "1234",
+		Message:   "counter#jobs=1",
+	}, actual)
+
+	actual, err = detectAndParseLog("<13>Oct 11 22:14:15 myhost cron: job finished")
+	require.NoError(t, err)
+	require.Equal(t, "cron", actual.Appname)
+	require.Equal(t, "-", actual.ProcID)
+	require.Equal(t, "job finished", actual.Message)
+}
+
+func TestGetDimensionPairsFromParams(t *testing.T) {
+	values := url.Values{
+		"dim1":     []string{"val1", "val2"},
+		"dim2":     []string{"val1"},
+		"app_name": []string{"test"},
 	}
 
-	values["app_name"] = []string{"test"}
 	expected := map[string]string{
 		"dim1":     "val1",
 		"dim2":     "val1",
 		"app_name": "test",
 	}
 
-	actual, _ := getDimensionPairsFromParams(values)
+	actual := getDimensionPairsFromParams(values, nil)
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v datapoints, received %v datapoints", expected, actual)
+	}
+
+	// Dimensions mapped from the drain token take precedence
+	expected = map[string]string{
+		"dim1":     "val1",
+		"dim2":     "val1",
+		"app_name": "mapped",
+		"team":     "payments",
+	}
+
+	actual = getDimensionPairsFromParams(values, map[string]string{"app_name": "mapped", "team": "payments"})
 
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("Expected %v datapoints, received %v datapoints", expected, actual)
 	}
 }
+
+func TestProcessMetricsQuotedValues(t *testing.T) {
+	actual, err := detectAndParseLog(`<190>1 2019-12-21T22:21:26Z host app web.1 - gauge#queue=3 sfxdimension#team="data platform" sfxdimension#query="a=b"`)
+	require.NoError(t, err)
+
+	metrics, dims := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+	require.Len(t, metrics, 1)
+	require.Equal(t, "queue", metrics[0].Name)
+	require.Equal(t, "data platform", dims["team"])
+	require.Equal(t, "a=b", dims["query"])
+}
+
+func TestRouterRequestCount(t *testing.T) {
+	actual, err := detectAndParseLog(`<158>1 2012-10-11T03:47:20+00:00 host heroku router - at=error code=H12 desc="Request timeout" method=GET path=/ host=myapp.herokuapp.com dyno=web.1 connect=1ms service=30000ms status=503 bytes=0 protocol=http`)
+	require.NoError(t, err)
+
+	metrics, dims := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+	require.Len(t, metrics, 7)
+	require.Equal(t, "heroku.router_requests", metrics[6].Name)
+	require.Equal(t, float64(1), metrics[6].Value)
+	require.Equal(t, map[string]string{"at": "error", "status_class": "5xx"}, metrics[6].Dimensions)
+
+	require.Equal(t, "503", dims["status"])
+	require.Equal(t, "H12", dims["code"])
+	require.Empty(t, dims["at"])
+}
+
+func TestHistogramMetrics(t *testing.T) {
+	actual, err := detectAndParseLog(`<190>1 2019-12-21T22:21:26Z host app web.1 - histogram#query_time=12ms`)
+	require.NoError(t, err)
+
+	metrics, _ := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+	require.Len(t, metrics, 1)
+	require.Equal(t, "query_time", metrics[0].Name)
+	require.Equal(t, registry.Histogram, metrics[0].Type)
+	require.Equal(t, float64(12), metrics[0].Value)
+}
+
+func TestL2metMetrics(t *testing.T) {
+	actual, err := detectAndParseLog(`<190>1 2019-12-21T22:21:26Z host app web.1 - source=db-replica measure#db.latency=12ms count#jobs.enqueued=2 unique#user=42 unique#empty=`)
+	require.NoError(t, err)
+
+	metrics, dims := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+	require.Equal(t, []*registry.MetricVal{
+		{Name: "db.latency", Type: registry.Measure, Value: 12},
+		{Name: "jobs.enqueued", Type: datapoint.Count, Value: 2},
+		{Name: "user", Type: registry.Unique, Member: "42"},
+	}, metrics)
+
+	require.Equal(t, "db-replica", dims["source"])
+	require.Equal(t, "web.1", dims["dyno"])
+	require.Equal(t, "web", dims["process_type"])
+}
+
+func TestPostgresMetrics(t *testing.T) {
+	actual, err := detectAndParseLog(`<134>1 2020-01-13T18:27:50+00:00 host app heroku-postgres - source=HEROKU_POSTGRESQL_VIOLET addon=postgresql-curved-12345 sample#db_size=26987164184bytes sample#active-connections=92 sample#load-avg-1m=0.315 sample#memory-free=1024kB sample#read-iops=0`)
+	require.NoError(t, err)
+
+	metrics, dims := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+	require.Equal(t, []*registry.MetricVal{
+		{Name: "heroku.postgres.db_size", Type: datapoint.Gauge, Value: 26987164184},
+		{Name: "heroku.postgres.active_connections", Type: datapoint.Gauge, Value: 92},
+		{Name: "heroku.postgres.load_avg_1m", Type: datapoint.Gauge, Value: 0.315},
+		{Name: "heroku.postgres.memory_free", Type: datapoint.Gauge, Value: 1024 * 1024},
+		{Name: "heroku.postgres.read_iops", Type: datapoint.Gauge, Value: 0},
+	}, metrics)
+
+	require.Equal(t, map[string]string{
+		"app_name":            "test-app",
+		"addon":               "postgresql-curved-12345",
+		"database_attachment": "HEROKU_POSTGRESQL_VIOLET",
+	}, dims)
+}
+
+func TestRedisMetrics(t *testing.T) {
+	actual, err := detectAndParseLog(`<134>1 2020-01-13T18:27:50+00:00 host app heroku-redis - source=REDIS addon=redis-cubic-12345 sample#active-connections=1 sample#memory-total=15664876kB sample#memory-redis=912904bytes sample#hit-rate=0.5 sample#evicted-keys=3`)
+	require.NoError(t, err)
+
+	metrics, dims := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+	require.Equal(t, []*registry.MetricVal{
+		{Name: "heroku.redis.active_connections", Type: datapoint.Gauge, Value: 1},
+		{Name: "heroku.redis.memory_total", Type: datapoint.Gauge, Value: 15664876 * 1024},
+		{Name: "heroku.redis.memory_redis", Type: datapoint.Gauge, Value: 912904},
+		{Name: "heroku.redis.hit_rate", Type: datapoint.Gauge, Value: 0.5},
+		{Name: "heroku.redis.evicted_keys", Type: datapoint.Count, Value: 3},
+	}, metrics)
+
+	require.Equal(t, map[string]string{
+		"app_name":   "test-app",
+		"addon":      "redis-cubic-12345",
+		"attachment": "REDIS",
+	}, dims)
+}
+
+func TestPlatformErrors(t *testing.T) {
+	testCases := []struct {
+		line         string
+		expectedDims map[string]string
+	}{
+		{
+			line:         `<45>1 2019-12-11T22:29:21+00:00 host heroku web.1 - Error R14 (Memory quota exceeded)`,
+			expectedDims: map[string]string{"code": "R14", "process_type": "web", "dyno": "web.1"},
+		},
+		{
+			line:         `<172>1 2019-12-11T22:29:21+00:00 host heroku logplex - Error L10 (output buffer overflow): 500 messages dropped since 2019-12-11T22:29:01+00:00.`,
+			expectedDims: map[string]string{"code": "L10", "process_type": "logplex", "dyno": "logplex"},
+		},
+		{
+			line:         `<158>1 2019-12-11T22:29:21+00:00 host heroku router - at=error code=H10 desc="App crashed" method=GET path="/" host=myapp.herokuapp.com dyno=web.2 connect= service= status=503 bytes= protocol=https`,
+			expectedDims: map[string]string{"code": "H10", "process_type": "web", "dyno": "web.2"},
+		},
+		{
+			// Only lines logged by Heroku are platform errors
+			line: `<190>1 2019-12-11T22:29:21+00:00 host app web.1 - Error R14 (Memory quota exceeded)`,
+		},
+	}
+
+	for _, tc := range testCases {
+		actual, err := detectAndParseLog(tc.line)
+		require.NoError(t, err)
+
+		metrics, dims := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+		var platformErrors []*registry.MetricVal
+		for _, metric := range metrics {
+			if metric.Name == "heroku.platform_errors" {
+				platformErrors = append(platformErrors, metric)
+			}
+		}
+
+		if tc.expectedDims == nil {
+			require.Emptyf(t, platformErrors, "Unexpected platform error in %s", tc.line)
+			continue
+		}
+
+		require.Lenf(t, platformErrors, 1, "Expected a platform error in %s", tc.line)
+		require.Equal(t, datapoint.Count, platformErrors[0].Type)
+
+		for key, value := range tc.expectedDims {
+			require.Equalf(t, value, mergeStringMaps(dims, platformErrors[0].Dimensions)[key], "Unexpected %s in %s", key, tc.line)
+		}
+	}
+}
+
+func TestDynoEvents(t *testing.T) {
+	testCases := []struct {
+		message      string
+		expectedName string
+		expectedDims map[string]string
+	}{
+		{"State changed from up to crashed", "heroku.dyno_state_changes", map[string]string{"from": "up", "to": "crashed"}},
+		{"Process exited with status 137", "heroku.dyno_exits", map[string]string{"exit_status": "137"}},
+		{"Stopping all processes with SIGTERM", "heroku.dyno_stops", map[string]string{"signal": "SIGTERM"}},
+		{"Stopping process with SIGKILL", "heroku.dyno_stops", map[string]string{"signal": "SIGKILL"}},
+		{"Starting process with command `bundle exec puma`", "", nil},
+	}
+
+	for _, tc := range testCases {
+		actual, err := detectAndParseLog("<45>1 2019-12-11T22:29:21+00:00 host heroku web.1 - " + tc.message)
+		require.NoError(t, err)
+
+		metrics, dims := processMetrics(actual, map[string]string{"app_name": "test-app"})
+
+		if tc.expectedName == "" {
+			require.Emptyf(t, metrics, "Unexpected metrics for %s", tc.message)
+			continue
+		}
+
+		require.Lenf(t, metrics, 1, "Expected a metric for %s", tc.message)
+		require.Equal(t, tc.expectedName, metrics[0].Name)
+		require.Equal(t, datapoint.Count, metrics[0].Type)
+		require.Equal(t, tc.expectedDims, metrics[0].Dimensions)
+		require.Equal(t, "web", dims["process_type"])
+	}
+}
+
+func TestMemoryPercentMetrics(t *testing.T) {
+	ll, err := detectAndParseLog(`<45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.1 - source=web.1 sample#memory_total=384.00MB sample#memory_swap=51.20MB sample#memory_quota=512.00MB`)
+	require.NoError(t, err)
+
+	metrics, _ := processMetrics(ll, map[string]string{"app_name": "test-app"})
+
+	values := map[string]float64{}
+	for _, metric := range metrics {
+		values[metric.Name] = metric.Value
+	}
+
+	require.InDelta(t, 75.0, values["heroku.memory_utilization_percent"], 0.001)
+	require.InDelta(t, 10.0, values["heroku.memory_swap_percent"], 0.001)
+
+	// Percentages can't be derived without the quota
+	ll, err = detectAndParseLog(`<45>1 2019-12-11T22:29:21.372436+00:00 host heroku web.1 - source=web.1 sample#memory_total=384.00MB`)
+	require.NoError(t, err)
+
+	metrics, _ = processMetrics(ll, map[string]string{"app_name": "test-app"})
+	require.Len(t, metrics, 1)
+}
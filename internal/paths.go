@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Normalized paths beyond the maximum number of paths of an app are reported
+// as this
+const otherRouterPath = "other"
+
+// Path segments that are numeric IDs, i.e. "/users/123"
+var numericPathSegmentFormat = regexp.MustCompile(`^\d+$`)
+
+// PathTemplate rewrites the paths of router logs matching a regular expression,
+// i.e. "^/users/[^/]+/avatar$" to "/users/:name/avatar", so that requests to
+// the same endpoint have the same path dimension
+type PathTemplate struct {
+	Pattern string `json:"pattern"`
+	// Replacement of the matching paths, which can refer to capture groups,
+	// i.e. "$1"
+	Template string `json:"template"`
+}
+
+type compiledPathTemplate struct {
+	pattern  *regexp.Regexp
+	template string
+}
+
+// Normalizes the paths of router logs, and caps the number of distinct paths
+// per app so that the path dimension doesn't create an unbounded number of
+// time series
+type routerPathNormalizer struct {
+	sync.Mutex
+
+	templates []compiledPathTemplate
+	maxPaths  int
+	// Normalized paths seen so far by app name
+	paths map[string]map[string]bool
+}
+
+func newRouterPathNormalizer(templates []PathTemplate, maxPaths int) (*routerPathNormalizer, error) {
+	n := &routerPathNormalizer{
+		maxPaths: maxPaths,
+		paths:    map[string]map[string]bool{},
+	}
+
+	for _, t := range templates {
+		pattern, err := regexp.Compile(t.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("path template %q has an invalid pattern: %v", t.Template, err)
+		}
+
+		n.templates = append(n.templates, compiledPathTemplate{pattern: pattern, template: t.Template})
+	}
+
+	return n, nil
+}
+
+// Returns the path dimension of a request to an app. The query string is
+// stripped, the first matching template is applied, and then segments that are
+// numeric IDs or UUIDs are replaced with ":id". Paths past the maximum number
+// of paths of the app are reported as "other".
+func (n *routerPathNormalizer) normalize(appName string, path string) string {
+	path = n.rewrite(path)
+
+	n.Lock()
+	defer n.Unlock()
+
+	paths := n.paths[appName]
+	if paths == nil {
+		paths = map[string]bool{}
+		n.paths[appName] = paths
+	}
+
+	if !paths[path] {
+		if n.maxPaths > 0 && len(paths) >= n.maxPaths {
+			return otherRouterPath
+		}
+
+		paths[path] = true
+	}
+
+	return path
+}
+
+func (n *routerPathNormalizer) rewrite(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+
+	for _, t := range n.templates {
+		if t.pattern.MatchString(path) {
+			path = t.pattern.ReplaceAllString(path, t.template)
+			break
+		}
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if numericPathSegmentFormat.MatchString(segment) || herokuObjectIDFormat.MatchString(segment) {
+			segments[i] = ":id"
+		}
+	}
+
+	if path = strings.Join(segments, "/"); path == "" {
+		return "/"
+	}
+
+	return path
+}
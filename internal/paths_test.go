@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterPathNormalizer(t *testing.T) {
+	n, err := newRouterPathNormalizer([]PathTemplate{
+		{Pattern: `^/users/[^/]+/avatar$`, Template: "/users/:name/avatar"},
+		{Pattern: `^/assets/.*`, Template: "/assets/*"},
+	}, 7)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"", "/"},
+		{"/orders?page=2", "/orders"},
+		{"/orders/123/items/45#top", "/orders/:id/items/:id"},
+		{"/apps/01234567-89ab-cdef-0123-456789abcdef", "/apps/:id"},
+		{"/users/jane/avatar", "/users/:name/avatar"},
+		{"/assets/app-3f2a.js", "/assets/*"},
+		{"/v2/orders", "/v2/orders"},
+		// Past 7 distinct paths, only known paths are kept
+		{"/search", "other"},
+		{"/orders/987", "other"},
+		{"/orders?page=3", "/orders"},
+	}
+
+	for _, tc := range testCases {
+		require.Equalf(t, tc.expected, n.normalize("test", tc.path), "Unexpected path for %s", tc.path)
+	}
+
+	// The limit is per app
+	require.Equal(t, "/search", n.normalize("other-app", "/search"))
+
+	_, err = newRouterPathNormalizer([]PathTemplate{{Pattern: `(`, Template: "/"}}, 5)
+	require.Error(t, err)
+}
+
+func TestProcessLineRouterPath(t *testing.T) {
+	conf := defaultConfig
+
+	line := `<158>1 2019-12-11T16:17:53.786555+00:00 host heroku router - at=info method=GET path="/orders/42?expand=items" host=test.herokuapp.com dyno=web.1 connect=0ms service=12ms status=200 bytes=146 protocol=https`
+
+	listener := newTestListener(t, conf)
+	require.True(t, listener.processLine(line, map[string]string{"app_name": "test"}))
+
+	for _, dp := range listener.registry.Datapoints() {
+		require.NotContains(t, dp.Dimensions, "path")
+	}
+
+	conf.RouterPathDimension = true
+
+	listener = newTestListener(t, conf)
+	require.True(t, listener.processLine(line, map[string]string{"app_name": "test"}))
+
+	dps := listener.registry.Datapoints()
+	require.NotEmpty(t, dps)
+
+	for _, dp := range dps {
+		require.Equal(t, "/orders/:id", dp.Dimensions["path"])
+	}
+}
@@ -17,6 +17,16 @@ type CounterCollector struct {
 	Dimensions map[string]string
 
 	count float64
+
+	// Counts of increments with a timestamp, by the timestamp. The timestamps
+	// are expected to be bucketed to the reporting interval already.
+	buckets map[time.Time]*countBucket
+}
+
+type countBucket struct {
+	count float64
+	// Whether the bucket changed since it was last reported
+	dirty bool
 }
 
 var _ sfxclient.Collector = &CounterCollector{}
@@ -29,7 +39,30 @@ func (c *CounterCollector) Add(val float64) {
 	c.count += val
 }
 
-// Datapoints returns the counter datapoint, or nil if there is no set metric name
+// AddAt adds an item to the bucket of the given timestamp. Buckets are kept
+// around after being reported, so that late items can be added to them, and
+// are reported again with their total count whenever they change. This way,
+// the latest datapoint for each timestamp always has the right count.
+func (c *CounterCollector) AddAt(val float64, ts time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.buckets == nil {
+		c.buckets = map[time.Time]*countBucket{}
+	}
+
+	b := c.buckets[ts]
+	if b == nil {
+		b = &countBucket{}
+		c.buckets[ts] = b
+	}
+
+	b.count += val
+	b.dirty = true
+}
+
+// Datapoints returns the counter datapoint, or nil if there is no set metric name.
+// Counters with timestamped increments only report the buckets that changed.
 func (c *CounterCollector) Datapoints() []*datapoint.Datapoint {
 	c.Lock()
 	defer c.Unlock()
@@ -37,7 +70,36 @@ func (c *CounterCollector) Datapoints() []*datapoint.Datapoint {
 	val := c.count
 	c.count = 0.0
 
-	return []*datapoint.Datapoint{
-		datapoint.New(c.MetricName, c.Dimensions, datapoint.NewFloatValue(val), datapoint.Count, time.Time{}),
+	if len(c.buckets) == 0 {
+		return []*datapoint.Datapoint{
+			datapoint.New(c.MetricName, c.Dimensions, datapoint.NewFloatValue(val), datapoint.Count, time.Time{}),
+		}
+	}
+
+	var out []*datapoint.Datapoint
+
+	if val != 0 {
+		out = append(out, datapoint.New(c.MetricName, c.Dimensions, datapoint.NewFloatValue(val), datapoint.Count, time.Time{}))
+	}
+
+	for ts, b := range c.buckets {
+		if b.dirty {
+			out = append(out, datapoint.New(c.MetricName, c.Dimensions, datapoint.NewFloatValue(b.count), datapoint.Count, ts))
+			b.dirty = false
+		}
+	}
+
+	return out
+}
+
+// Removes buckets older than the given time, once they've been reported
+func (c *CounterCollector) purgeBuckets(before time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for ts, b := range c.buckets {
+		if !b.dirty && ts.Before(before) {
+			delete(c.buckets, ts)
+		}
 	}
 }
@@ -2,6 +2,7 @@ package registry
 
 import (
 	"sync"
+	"time"
 
 	"github.com/signalfx/golib/v3/datapoint"
 	"github.com/signalfx/golib/v3/sfxclient"
@@ -15,6 +16,8 @@ type GaugeCollector struct {
 	Type       datapoint.MetricType
 
 	latest float64
+	// Timestamp of the latest value, if it has one
+	ts time.Time
 }
 
 var _ sfxclient.Collector = &GaugeCollector{}
@@ -25,6 +28,30 @@ func (g *GaugeCollector) Set(val float64) {
 	defer g.Unlock()
 
 	g.latest = val
+	g.ts = time.Time{}
+}
+
+// Add a change to the latest value of the gauge, which starts from 0
+func (g *GaugeCollector) Add(delta float64) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.latest += delta
+	g.ts = time.Time{}
+}
+
+// SetAt updates the gauge with a value that has a timestamp, ignoring values
+// older than the latest one
+func (g *GaugeCollector) SetAt(val float64, ts time.Time) {
+	g.Lock()
+	defer g.Unlock()
+
+	if ts.Before(g.ts) {
+		return
+	}
+
+	g.latest = val
+	g.ts = ts
 }
 
 // Datapoints returns the latest datapoint, or nil if there is no set metric name
@@ -32,7 +59,8 @@ func (g *GaugeCollector) Datapoints() []*datapoint.Datapoint {
 	g.Lock()
 	defer g.Unlock()
 
-	return []*datapoint.Datapoint{
-		sfxclient.GaugeF(g.MetricName, g.Dimensions, g.latest),
-	}
+	dp := sfxclient.GaugeF(g.MetricName, g.Dimensions, g.latest)
+	dp.Timestamp = g.ts
+
+	return []*datapoint.Datapoint{dp}
 }
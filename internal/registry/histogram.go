@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+)
+
+// Histogram is not an actual SignalFx metric type. Histogram metrics are
+// reported as a set of gauges and counters summarizing the distribution of
+// the values recorded in each report cycle.
+const Histogram datapoint.MetricType = 100
+
+// Measure is not an actual SignalFx metric type either. Measure metrics are
+// histograms reported the way l2met reports measurements. For more
+// information, see: https://github.com/ryandotsmith/l2met/wiki/Usage
+const Measure datapoint.MetricType = 101
+
+// Percentiles reported for histograms by default
+var DefaultPercentiles = []float64{50, 90, 95, 99}
+
+// Maximum number of values kept per report cycle to compute percentiles.
+// Past this, values are sampled uniformly.
+const maxHistogramSamples = 1024
+
+// A HistogramCollector tracks the distribution of values in between datapoint
+// collection cycles
+type HistogramCollector struct {
+	sync.Mutex
+	MetricName string
+	Dimensions map[string]string
+	// Percentiles to report, between 0 and 100
+	Percentiles []float64
+	// Whether to report the distribution the way l2met does, in which case
+	// Percentiles is ignored
+	L2met bool
+
+	samples []float64
+	// Number of values added, and of the observations they stand for
+	count        int64
+	observations float64
+	sum          float64
+	min          float64
+	max          float64
+
+	rand *rand.Rand
+}
+
+var _ sfxclient.Collector = &HistogramCollector{}
+
+// Add a value to the distribution, later reporting the result in the next
+// report cycle
+func (h *HistogramCollector) Add(val float64) {
+	h.AddWeighted(val, 1)
+}
+
+// AddWeighted adds a value that stands for weight observations, i.e. a value
+// sampled at a rate of 0.1 stands for 10 observations. The weight is applied
+// to the count and sum, while percentiles are computed from the values alone.
+func (h *HistogramCollector) AddWeighted(val float64, weight float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.count == 0 || val < h.min {
+		h.min = val
+	}
+
+	if h.count == 0 || val > h.max {
+		h.max = val
+	}
+
+	h.count++
+	h.observations += weight
+	h.sum += val * weight
+
+	if len(h.samples) < maxHistogramSamples {
+		h.samples = append(h.samples, val)
+		return
+	}
+
+	// Reservoir sampling, so that every value has the same chance of being
+	// kept regardless of when it was added
+	if h.rand == nil {
+		h.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	if i := h.rand.Int63n(h.count); i < maxHistogramSamples {
+		h.samples[i] = val
+	}
+}
+
+// Datapoints returns the percentiles, min, max, count and sum of the values
+// added since the last call, or nothing if no value was added
+func (h *HistogramCollector) Datapoints() []*datapoint.Datapoint {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.count == 0 {
+		return nil
+	}
+
+	sort.Float64s(h.samples)
+
+	var out []*datapoint.Datapoint
+	if h.L2met {
+		out = h.l2metDatapoints()
+	} else {
+		out = h.histogramDatapoints()
+	}
+
+	h.samples = h.samples[:0]
+	h.count = 0
+	h.observations = 0
+	h.sum = 0
+
+	return out
+}
+
+func (h *HistogramCollector) histogramDatapoints() []*datapoint.Datapoint {
+	out := make([]*datapoint.Datapoint, 0, len(h.Percentiles)+4)
+
+	for _, p := range h.Percentiles {
+		out = append(out, sfxclient.GaugeF(h.MetricName+"."+percentileSuffix(p), h.Dimensions, percentile(h.samples, p)))
+	}
+
+	return append(out,
+		sfxclient.GaugeF(h.MetricName+".min", h.Dimensions, h.min),
+		sfxclient.GaugeF(h.MetricName+".max", h.Dimensions, h.max),
+		datapoint.New(h.MetricName+".count", h.Dimensions, datapoint.NewIntValue(int64(math.Round(h.observations))), datapoint.Count, time.Time{}),
+		datapoint.New(h.MetricName+".sum", h.Dimensions, datapoint.NewFloatValue(h.sum), datapoint.Count, time.Time{}),
+	)
+}
+
+func (h *HistogramCollector) l2metDatapoints() []*datapoint.Datapoint {
+	return []*datapoint.Datapoint{
+		sfxclient.GaugeF(h.MetricName+".min", h.Dimensions, h.min),
+		sfxclient.GaugeF(h.MetricName+".max", h.Dimensions, h.max),
+		sfxclient.GaugeF(h.MetricName+".mean", h.Dimensions, h.sum/h.observations),
+		sfxclient.GaugeF(h.MetricName+".median", h.Dimensions, percentile(h.samples, 50)),
+		sfxclient.GaugeF(h.MetricName+".perc95", h.Dimensions, percentile(h.samples, 95)),
+		sfxclient.GaugeF(h.MetricName+".perc99", h.Dimensions, percentile(h.samples, 99)),
+		datapoint.New(h.MetricName+".count", h.Dimensions, datapoint.NewIntValue(int64(math.Round(h.observations))), datapoint.Count, time.Time{}),
+	}
+}
+
+// Returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank-1]
+}
+
+// Percentiles are reported as i.e. "p99", or "p99_9" for 99.9
+func percentileSuffix(p float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", 1)
+}
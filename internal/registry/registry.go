@@ -20,6 +20,15 @@ type MetricRegistry struct {
 	cumulativeCounters map[metricID]*CumulativeCollector
 	gauges             map[metricID]*GaugeCollector
 	counters           map[metricID]*CounterCollector
+	histograms         map[metricID]*HistogramCollector
+	uniques            map[metricID]*UniqueCollector
+
+	// Number of histogram values dropped because the metric was already
+	// recorded as another histogram type
+	conflictingHistogramValues int64
+
+	// Percentiles reported for histograms
+	percentiles []float64
 
 	// A linked list that we keep sorted by access time so that we can very
 	// quickly tell which collectors are expired and should be deleted.
@@ -50,8 +59,21 @@ func (mr *MetricRegistry) Datapoints() []*datapoint.Datapoint {
 		out = append(out, mr.cumulativeCounters[id].Datapoints()...)
 	}
 
+	for id := range mr.histograms {
+		out = append(out, mr.histograms[id].Datapoints()...)
+	}
+
+	for id := range mr.uniques {
+		out = append(out, mr.uniques[id].Datapoints()...)
+	}
+
+	// Timestamped counts are kept around for as long as the counters they
+	// belong to, in case more lines for the same timestamp show up
+	bucketExpiry := mr.currentTime().Add(-mr.expiryTimeout)
+
 	for id := range mr.counters {
 		out = append(out, mr.counters[id].Datapoints()...)
+		mr.counters[id].purgeBuckets(bucketExpiry)
 	}
 
 	mr.RUnlock()
@@ -67,6 +89,9 @@ func (mr *MetricRegistry) InternalMetrics() []*datapoint.Datapoint {
 		sfxclient.Gauge("sfx_heroku.tracked_metrics", map[string]string{"type": "cumulative_counter"}, int64(len(mr.cumulativeCounters))),
 		sfxclient.Gauge("sfx_heroku.tracked_metrics", map[string]string{"type": "gauge"}, int64(len(mr.gauges))),
 		sfxclient.Gauge("sfx_heroku.tracked_metrics", map[string]string{"type": "counter"}, int64(len(mr.counters))),
+		sfxclient.Gauge("sfx_heroku.tracked_metrics", map[string]string{"type": "histogram"}, int64(len(mr.histograms))),
+		sfxclient.Gauge("sfx_heroku.tracked_metrics", map[string]string{"type": "unique"}, int64(len(mr.uniques))),
+		sfxclient.Cumulative("sfx_heroku.conflicting_histogram_values", nil, mr.conflictingHistogramValues),
 	}
 }
 
@@ -74,15 +99,35 @@ type MetricVal struct {
 	Name  string
 	Type  datapoint.MetricType
 	Value float64
+	// Optional, only supported for gauges and counters. Datapoints are
+	// reported at the time of collection when not set.
+	Timestamp time.Time
+	// The member to add to the set of unique metrics, instead of Value
+	Member string
+	// Number of observations a histogram value stands for, i.e. 10 for a
+	// value sampled at a rate of 0.1. A value stands for 1 observation when
+	// not set.
+	Weight float64
+	// Whether the Value of a gauge is a change to add to its current value,
+	// instead of its new value
+	Delta bool
+	// Optional dimensions specific to this metric, which take precedence
+	// over the ones passed along with it
+	Dimensions map[string]string
 }
 
 var _ sfxclient.Collector = &MetricRegistry{}
 
-func New(expiryTimeout time.Duration) *MetricRegistry {
+// New returns a registry that expires metrics that were not updated for
+// expiryTimeout, and reports the given percentiles for histograms
+func New(expiryTimeout time.Duration, percentiles []float64) *MetricRegistry {
 	return &MetricRegistry{
 		cumulativeCounters: map[metricID]*CumulativeCollector{},
 		gauges:             map[metricID]*GaugeCollector{},
 		counters:           map[metricID]*CounterCollector{},
+		histograms:         map[metricID]*HistogramCollector{},
+		uniques:            map[metricID]*UniqueCollector{},
+		percentiles:        percentiles,
 		lastAccesses:       make(map[metricID]*list.Element),
 		expiryTimeout:      expiryTimeout,
 		currentTime:        time.Now,
@@ -99,6 +144,10 @@ func (mr *MetricRegistry) UpdateMetric(mv *MetricVal, dims map[string]string) {
 	mr.Lock()
 	defer mr.Unlock()
 
+	if len(mv.Dimensions) > 0 {
+		dims = mergeDimensions(dims, mv.Dimensions)
+	}
+
 	id := idForMetric(mv.Name, dims)
 
 	switch mv.Type {
@@ -110,7 +159,13 @@ func (mr *MetricRegistry) UpdateMetric(mv *MetricVal, dims map[string]string) {
 			}
 		}
 
-		mr.gauges[id].Set(mv.Value)
+		if mv.Delta {
+			mr.gauges[id].Add(mv.Value)
+		} else if mv.Timestamp.IsZero() {
+			mr.gauges[id].Set(mv.Value)
+		} else {
+			mr.gauges[id].SetAt(mv.Value, mv.Timestamp)
+		}
 	case datapoint.Count:
 		if c := mr.counters[id]; c == nil {
 			mr.counters[id] = &CounterCollector{
@@ -119,7 +174,11 @@ func (mr *MetricRegistry) UpdateMetric(mv *MetricVal, dims map[string]string) {
 			}
 		}
 
-		mr.counters[id].Add(mv.Value)
+		if mv.Timestamp.IsZero() {
+			mr.counters[id].Add(mv.Value)
+		} else {
+			mr.counters[id].AddAt(mv.Value, mv.Timestamp)
+		}
 	case datapoint.Counter:
 		if c := mr.cumulativeCounters[id]; c == nil {
 			mr.cumulativeCounters[id] = &CumulativeCollector{
@@ -129,6 +188,43 @@ func (mr *MetricRegistry) UpdateMetric(mv *MetricVal, dims map[string]string) {
 		}
 
 		mr.cumulativeCounters[id].Add(mv.Value)
+	case Histogram, Measure:
+		l2met := mv.Type == Measure
+
+		if c := mr.histograms[id]; c == nil {
+			mr.histograms[id] = &HistogramCollector{
+				MetricName:  mv.Name,
+				Dimensions:  dims,
+				Percentiles: mr.percentiles,
+				L2met:       l2met,
+			}
+		} else if c.L2met != l2met {
+			// Both would report datapoints with the same names, i.e. ".min",
+			// so values are only recorded for the type seen first
+			mr.conflictingHistogramValues++
+
+			log.WithFields(log.Fields{
+				"metric": mv.Name,
+				"type":   mv.Type,
+			}).Debug("Dropping value of metric already recorded as another histogram type")
+
+			return
+		}
+
+		if mv.Weight > 0 {
+			mr.histograms[id].AddWeighted(mv.Value, mv.Weight)
+		} else {
+			mr.histograms[id].Add(mv.Value)
+		}
+	case Unique:
+		if c := mr.uniques[id]; c == nil {
+			mr.uniques[id] = &UniqueCollector{
+				MetricName: mv.Name,
+				Dimensions: dims,
+			}
+		}
+
+		mr.uniques[id].Add(mv.Member)
 	default:
 		log.WithFields(log.Fields{
 			"metric": mv.Name,
@@ -149,6 +245,20 @@ func idForMetric(name string, dims map[string]string) metricID {
 	return metricID(id)
 }
 
+func mergeDimensions(dims map[string]string, overrides map[string]string) map[string]string {
+	out := make(map[string]string, len(dims)+len(overrides))
+
+	for k, v := range dims {
+		out[k] = v
+	}
+
+	for k, v := range overrides {
+		out[k] = v
+	}
+
+	return out
+}
+
 func sortKeys(m map[string]string) []string {
 	var keys sort.StringSlice
 	for key := range m {
@@ -217,6 +327,8 @@ func (mr *MetricRegistry) purgeOldCollectors() {
 		delete(mr.cumulativeCounters, acc.id)
 		delete(mr.gauges, acc.id)
 		delete(mr.counters, acc.id)
+		delete(mr.histograms, acc.id)
+		delete(mr.uniques, acc.id)
 		delete(mr.lastAccesses, acc.id)
 	}
 }
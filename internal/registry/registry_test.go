@@ -17,7 +17,7 @@ func advanceTime(r *MetricRegistry, minutes int64) {
 }
 
 func TestExpiration(t *testing.T) {
-	mr := New(5 * time.Minute)
+	mr := New(5*time.Minute, nil)
 
 	setTime(mr, time.Unix(100, 0))
 
@@ -50,3 +50,215 @@ func TestExpiration(t *testing.T) {
 		}
 	}
 }
+
+func TestTimestampedCounters(t *testing.T) {
+	mr := New(5*time.Minute, nil)
+
+	setTime(mr, time.Unix(1000, 0))
+
+	dims := map[string]string{"a": "1"}
+	ts := time.Unix(990, 0)
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Count, Value: 1, Timestamp: ts}, dims)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Count, Value: 2, Timestamp: ts}, dims)
+
+	dps := mr.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, ts, dps[0].Timestamp)
+	require.Equal(t, datapoint.NewFloatValue(3), dps[0].Value)
+
+	// Buckets are only reported again when they change
+	require.Len(t, mr.Datapoints(), 0)
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Count, Value: 4, Timestamp: ts}, dims)
+
+	dps = mr.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, datapoint.NewFloatValue(7), dps[0].Value)
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Count, Value: 1, Timestamp: time.Unix(1000, 0)}, dims)
+	advanceTime(mr, 6)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Count, Value: 1, Timestamp: time.Unix(1350, 0)}, dims)
+
+	require.Len(t, mr.Datapoints(), 2)
+	require.Len(t, mr.counters[idForMetric("test", dims)].buckets, 1)
+}
+
+func TestTimestampedGauges(t *testing.T) {
+	mr := New(5*time.Minute, nil)
+
+	dims := map[string]string{"a": "1"}
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Gauge, Value: 1, Timestamp: time.Unix(1000, 0)}, dims)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Gauge, Value: 2, Timestamp: time.Unix(990, 0)}, dims)
+
+	dps := mr.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, time.Unix(1000, 0), dps[0].Timestamp)
+	require.Equal(t, datapoint.NewFloatValue(1), dps[0].Value)
+}
+
+func TestMetricDimensions(t *testing.T) {
+	mr := New(5*time.Minute, nil)
+
+	dims := map[string]string{"a": "1", "b": "1"}
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Count, Value: 1, Dimensions: map[string]string{"b": "2"}}, dims)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Count, Value: 1}, dims)
+
+	dps := mr.Datapoints()
+	require.Len(t, dps, 2)
+
+	values := map[string]bool{}
+
+	for _, dp := range dps {
+		require.Equal(t, "1", dp.Dimensions["a"])
+		values[dp.Dimensions["b"]] = true
+	}
+
+	require.Equal(t, map[string]bool{"1": true, "2": true}, values)
+	require.Equal(t, map[string]string{"a": "1", "b": "1"}, dims)
+}
+
+func TestHistograms(t *testing.T) {
+	mr := New(5*time.Minute, []float64{50, 99.9})
+
+	dims := map[string]string{"a": "1"}
+
+	for i := 1; i <= 100; i++ {
+		mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: float64(i)}, dims)
+	}
+
+	values := map[string]datapoint.Value{}
+	for _, dp := range mr.Datapoints() {
+		values[dp.Metric] = dp.Value
+	}
+
+	require.Equal(t, map[string]datapoint.Value{
+		"test.p50":   datapoint.NewFloatValue(50),
+		"test.p99_9": datapoint.NewFloatValue(100),
+		"test.min":   datapoint.NewFloatValue(1),
+		"test.max":   datapoint.NewFloatValue(100),
+		"test.count": datapoint.NewIntValue(100),
+		"test.sum":   datapoint.NewFloatValue(5050),
+	}, values)
+
+	// Distributions are reset every report cycle
+	require.Len(t, mr.Datapoints(), 0)
+
+	for i := 0; i < 2*maxHistogramSamples; i++ {
+		mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: float64(i)}, dims)
+	}
+
+	require.Len(t, mr.histograms[idForMetric("test", dims)].samples, maxHistogramSamples)
+	require.Len(t, mr.Datapoints(), 6)
+}
+
+func TestWeightedHistograms(t *testing.T) {
+	mr := New(5*time.Minute, []float64{50})
+
+	// Values sampled at a rate of 0.25
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: 10, Weight: 4}, nil)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: 20, Weight: 4}, nil)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: 30}, nil)
+
+	values := map[string]datapoint.Value{}
+	for _, dp := range mr.Datapoints() {
+		values[dp.Metric] = dp.Value
+	}
+
+	require.Equal(t, datapoint.NewIntValue(9), values["test.count"])
+	require.Equal(t, datapoint.NewFloatValue(150), values["test.sum"])
+	require.Equal(t, datapoint.NewFloatValue(20), values["test.p50"])
+}
+
+func TestGaugeDeltas(t *testing.T) {
+	mr := New(5*time.Minute, nil)
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Gauge, Value: 5, Delta: true}, nil)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Gauge, Value: -2, Delta: true}, nil)
+	require.Equal(t, datapoint.NewFloatValue(3), mr.Datapoints()[0].Value)
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Gauge, Value: 10}, nil)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: datapoint.Gauge, Value: 1, Delta: true}, nil)
+	require.Equal(t, datapoint.NewFloatValue(11), mr.Datapoints()[0].Value)
+}
+
+func TestMeasures(t *testing.T) {
+	mr := New(5*time.Minute, nil)
+
+	for i := 1; i <= 100; i++ {
+		mr.UpdateMetric(&MetricVal{Name: "test", Type: Measure, Value: float64(i)}, nil)
+	}
+
+	values := map[string]datapoint.Value{}
+	for _, dp := range mr.Datapoints() {
+		values[dp.Metric] = dp.Value
+	}
+
+	require.Equal(t, map[string]datapoint.Value{
+		"test.min":    datapoint.NewFloatValue(1),
+		"test.max":    datapoint.NewFloatValue(100),
+		"test.mean":   datapoint.NewFloatValue(50.5),
+		"test.median": datapoint.NewFloatValue(50),
+		"test.perc95": datapoint.NewFloatValue(95),
+		"test.perc99": datapoint.NewFloatValue(99),
+		"test.count":  datapoint.NewIntValue(100),
+	}, values)
+}
+
+// Histograms and measures of the same name and dimensions would report
+// datapoints with the same names, so only the type seen first is recorded
+func TestHistogramsAndMeasuresOfSameName(t *testing.T) {
+	mr := New(5*time.Minute, []float64{50})
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: 1}, nil)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Measure, Value: 100}, nil)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: 3}, nil)
+
+	values := map[string]datapoint.Value{}
+	for _, dp := range mr.Datapoints() {
+		values[dp.Metric] = dp.Value
+	}
+
+	require.Equal(t, map[string]datapoint.Value{
+		"test.p50":   datapoint.NewFloatValue(1),
+		"test.min":   datapoint.NewFloatValue(1),
+		"test.max":   datapoint.NewFloatValue(3),
+		"test.count": datapoint.NewIntValue(2),
+		"test.sum":   datapoint.NewFloatValue(4),
+	}, values)
+
+	// And the other way around
+	mr = New(5*time.Minute, nil)
+
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Measure, Value: 1}, nil)
+	mr.UpdateMetric(&MetricVal{Name: "test", Type: Histogram, Value: 100}, nil)
+
+	for _, dp := range mr.Datapoints() {
+		require.NotEqual(t, datapoint.NewFloatValue(100), dp.Value, dp.Metric)
+	}
+
+	internal := map[string]datapoint.Value{}
+	for _, dp := range mr.InternalMetrics() {
+		internal[dp.Metric] = dp.Value
+	}
+
+	require.Equal(t, datapoint.NewIntValue(1), internal["sfx_heroku.conflicting_histogram_values"])
+}
+
+func TestUniques(t *testing.T) {
+	mr := New(5*time.Minute, nil)
+
+	for _, member := range []string{"a", "b", "a", "c"} {
+		mr.UpdateMetric(&MetricVal{Name: "test", Type: Unique, Member: member}, nil)
+	}
+
+	dps := mr.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, datapoint.Gauge, dps[0].MetricType)
+	require.Equal(t, datapoint.NewIntValue(3), dps[0].Value)
+
+	// Distinct members are counted per report cycle
+	require.Len(t, mr.Datapoints(), 0)
+}
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"sync"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+)
+
+// Unique is not an actual SignalFx metric type. Unique metrics are reported
+// as a gauge of the number of distinct members added in each report cycle.
+const Unique datapoint.MetricType = 102
+
+// Maximum number of distinct members tracked per report cycle, past which
+// new members are not counted
+const maxUniqueMembers = 100000
+
+// A UniqueCollector tracks the number of distinct members added in between
+// datapoint collection cycles
+type UniqueCollector struct {
+	sync.Mutex
+	MetricName string
+	Dimensions map[string]string
+
+	members map[string]struct{}
+}
+
+var _ sfxclient.Collector = &UniqueCollector{}
+
+// Add a member to the set, later reporting the number of distinct members
+// in the next report cycle
+func (u *UniqueCollector) Add(member string) {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.members == nil {
+		u.members = map[string]struct{}{}
+	}
+
+	if len(u.members) < maxUniqueMembers {
+		u.members[member] = struct{}{}
+	}
+}
+
+// Datapoints returns the number of distinct members added since the last
+// call, or nothing if no member was added
+func (u *UniqueCollector) Datapoints() []*datapoint.Datapoint {
+	u.Lock()
+	defer u.Unlock()
+
+	if len(u.members) == 0 {
+		return nil
+	}
+
+	count := len(u.members)
+	u.members = nil
+
+	return []*datapoint.Datapoint{
+		sfxclient.Gauge(u.MetricName, u.Dimensions, int64(count)),
+	}
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nilValue = "-"
+
+// Maximum lengths of the header fields
+const (
+	maxTimestampLength = 64
+	maxHostnameLength  = 255
+	maxAppnameLength   = 48
+	maxProcIDLength    = 128
+	maxMsgIDLength     = 32
+)
+
+// Parses a syslog message in the format described here:
+// https://tools.ietf.org/html/rfc5424#section-6
+// Returns nil if the line is not a valid RFC 5424 message. Note that Heroku
+// omits the STRUCTURED-DATA field altogether, so the message is expected to
+// directly follow the MSGID field, unless it looks like structured data.
+// For more information, see:
+// https://stackoverflow.com/questions/25163830/explain-format-of-heroku-logs
+func parseRFC5424(line string) *logLine {
+	line = stripOctetCount(line)
+
+	if len(line) == 0 || line[0] != '<' {
+		return nil
+	}
+
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil
+	}
+
+	// PRI is 1 to 3 digits, up to 191. Atoi alone would accept signs.
+	pri := line[1:end]
+	if !isDigits(pri) {
+		return nil
+	}
+
+	if n, err := strconv.Atoi(pri); err != nil || n > 191 {
+		return nil
+	}
+
+	rest := line[end+1:]
+	ll := logLine{PRI: pri}
+
+	var ok bool
+
+	if ll.Version, rest, ok = nextHeaderField(rest, 3); !ok || !isVersion(ll.Version) {
+		return nil
+	}
+
+	if ll.Timestamp, rest, ok = nextHeaderField(rest, maxTimestampLength); !ok || !isTimestamp(ll.Timestamp) {
+		return nil
+	}
+
+	if ll.Hostname, rest, ok = nextHeaderField(rest, maxHostnameLength); !ok {
+		return nil
+	}
+
+	if ll.Appname, rest, ok = nextHeaderField(rest, maxAppnameLength); !ok {
+		return nil
+	}
+
+	if ll.ProcID, rest, ok = nextHeaderField(rest, maxProcIDLength); !ok {
+		return nil
+	}
+
+	if ll.MsgID, rest, ok = nextHeaderField(rest, maxMsgIDLength); !ok {
+		// The message may be empty, in which case there is no trailing space
+		if ll.MsgID, ok = lastHeaderField(rest, maxMsgIDLength); !ok {
+			return nil
+		}
+
+		return &ll
+	}
+
+	// Logplex frames have no STRUCTURED-DATA, so a message starting with a
+	// NILVALUE or structured data is kept as is for them
+	switch {
+	case rest == nilValue && !isLogplexHostname(ll.Hostname):
+		rest = ""
+	case strings.HasPrefix(rest, nilValue+" ") && !isLogplexHostname(ll.Hostname):
+		rest = rest[len(nilValue)+1:]
+	case strings.HasPrefix(rest, "[") && !isLogplexHostname(ll.Hostname):
+		ll.StructuredData, rest = splitStructuredData(rest)
+	}
+
+	// UTF-8 messages may start with a byte order mark
+	ll.Message = strings.TrimPrefix(rest, "\xEF\xBB\xBF")
+
+	return &ll
+}
+
+// Logplex frames start with an octet count, which is ignored if it was not
+// stripped before
+func stripOctetCount(line string) string {
+	i := strings.IndexByte(line, '<')
+	if i > 0 && strings.TrimLeft(line[:i], "0123456789 ") == "" {
+		return line[i:]
+	}
+
+	return line
+}
+
+// Returns the header field at the start of s, which must be followed by a
+// space, and the rest of s after the space
+func nextHeaderField(s string, maxLength int) (string, string, bool) {
+	i := strings.IndexByte(s, ' ')
+	if i < 1 || i > maxLength || !isPrintableASCII(s[:i]) {
+		return "", s, false
+	}
+
+	return s[:i], s[i+1:], true
+}
+
+func lastHeaderField(s string, maxLength int) (string, bool) {
+	if len(s) < 1 || len(s) > maxLength || !isPrintableASCII(s) {
+		return "", false
+	}
+
+	return s, true
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 33 || s[i] > 126 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VERSION is a non-zero digit followed by up to 2 digits
+func isVersion(s string) bool {
+	return s != "" && s[0] != '0' && isDigits(s)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Logplex sets the hostname to "host" in HTTPS drain requests, and to the
+// drain token (i.e. "d.f14da5dd-2f4d-4a70-a0b1-b4e6ef1e8c8f") in syslog drains
+func isLogplexHostname(hostname string) bool {
+	return hostname == "host" || strings.HasPrefix(hostname, "d.") && herokuObjectIDFormat.MatchString(hostname[2:])
+}
+
+// Timestamps are either NILVALUE or RFC 3339 timestamps, with a "Z" or
+// numeric offset and any number of fractional second digits
+func isTimestamp(s string) bool {
+	if s == nilValue {
+		return true
+	}
+
+	_, err := parseTimestamp(s)
+
+	return err == nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.ToUpper(s))
+}
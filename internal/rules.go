@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+	log "github.com/sirupsen/logrus"
+)
+
+// Name of the capture group holding the value of a rule by default
+const defaultRuleValueGroup = "value"
+
+// LogRule derives a metric from log lines matching a regular expression, for
+// apps that don't log metrics in any of the supported formats.
+type LogRule struct {
+	// Name of the metric to report
+	Metric string `json:"metric"`
+	// One of "counter", "gauge" or "histogram"
+	Type string `json:"type"`
+	// Regular expression applied to the message of log lines. Named capture
+	// groups, other than the one holding the value, are added as dimensions.
+	Pattern string `json:"pattern"`
+	// Name of the capture group holding the value of the metric, "value" by
+	// default. Counters are incremented by 1 when there is no such group.
+	ValueGroup string `json:"value_group"`
+	// Only lines with this syslog appname (i.e. "app") are matched when set
+	Appname string `json:"appname"`
+	// Only lines with this procid (i.e. "web.1") or process type (i.e.
+	// "web") are matched when set
+	ProcID string `json:"procid"`
+}
+
+type compiledLogRule struct {
+	LogRule
+
+	pattern    *regexp.Regexp
+	metricType datapoint.MetricType
+}
+
+var logRuleTypes = map[string]datapoint.MetricType{
+	"counter":   datapoint.Count,
+	"gauge":     datapoint.Gauge,
+	"histogram": registry.Histogram,
+}
+
+func compileLogRules(rules []LogRule) ([]*compiledLogRule, error) {
+	out := make([]*compiledLogRule, 0, len(rules))
+
+	for _, rule := range rules {
+		if rule.Metric == "" {
+			return nil, fmt.Errorf("log rule with pattern %q has no metric name", rule.Pattern)
+		}
+
+		metricType, ok := logRuleTypes[rule.Type]
+		if !ok {
+			return nil, fmt.Errorf("log rule for %s has unsupported type %q", rule.Metric, rule.Type)
+		}
+
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("log rule for %s has an invalid pattern: %v", rule.Metric, err)
+		}
+
+		if rule.ValueGroup == "" {
+			rule.ValueGroup = defaultRuleValueGroup
+		}
+
+		if metricType != datapoint.Count && !hasCaptureGroup(pattern, rule.ValueGroup) {
+			return nil, fmt.Errorf("log rule for %s has no %q capture group", rule.Metric, rule.ValueGroup)
+		}
+
+		out = append(out, &compiledLogRule{LogRule: rule, pattern: pattern, metricType: metricType})
+	}
+
+	return out, nil
+}
+
+func hasCaptureGroup(pattern *regexp.Regexp, name string) bool {
+	for _, groupName := range pattern.SubexpNames() {
+		if groupName == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the metrics of all the rules matching a log line
+func applyLogRules(rules []*compiledLogRule, ll *logLine) []*registry.MetricVal {
+	var out []*registry.MetricVal
+
+	for _, rule := range rules {
+		if metric := rule.apply(ll); metric != nil {
+			out = append(out, metric)
+		}
+	}
+
+	return out
+}
+
+func (r *compiledLogRule) apply(ll *logLine) *registry.MetricVal {
+	if r.Appname != "" && r.Appname != ll.Appname {
+		return nil
+	}
+
+	if r.ProcID != "" && r.ProcID != ll.ProcID && r.ProcID != strings.Split(ll.ProcID, ".")[0] {
+		return nil
+	}
+
+	match := r.pattern.FindStringSubmatch(ll.Message)
+	if match == nil {
+		return nil
+	}
+
+	metric := &registry.MetricVal{
+		Name:       r.Metric,
+		Type:       r.metricType,
+		Value:      1,
+		Dimensions: map[string]string{},
+	}
+
+	for i, name := range r.pattern.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+
+		if name != r.ValueGroup {
+			metric.Dimensions[name] = match[i]
+			continue
+		}
+
+		// The value group may be optional for counters
+		if match[i] == "" && r.metricType == datapoint.Count {
+			continue
+		}
+
+		value, err := getNumericValue(match[i])
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"metric": r.Metric,
+			}).Debug("Unable to get value of log rule metric. Will be dropped.")
+
+			return nil
+		}
+
+		metric.Value = *value
+	}
+
+	return metric
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRules(t *testing.T) {
+	rules, err := compileLogRules([]LogRule{
+		{Metric: "checkouts", Type: "counter", Pattern: `checkout completed plan=(?P<plan>\w+)`, Appname: "app", ProcID: "web"},
+		{Metric: "checkout_time", Type: "histogram", Pattern: `checkout completed .* in (?P<value>\S+)`},
+		{Metric: "queue_depth", Type: "gauge", Pattern: `queue (?P<queue>\w+) has (?P<jobs>\d+) jobs`, ValueGroup: "jobs", ProcID: "worker.1"},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		line     string
+		expected []*registry.MetricVal
+	}{
+		{
+			line: `<190>1 2020-01-13T18:27:50+00:00 host app web.1 - checkout completed plan=pro in 120ms`,
+			expected: []*registry.MetricVal{
+				{Name: "checkouts", Type: datapoint.Count, Value: 1, Dimensions: map[string]string{"plan": "pro"}},
+				{Name: "checkout_time", Type: registry.Histogram, Value: 120, Dimensions: map[string]string{}},
+			},
+		},
+		{
+			line: `<190>1 2020-01-13T18:27:50+00:00 host app worker.1 - checkout completed plan=pro in 1.5s`,
+			expected: []*registry.MetricVal{
+				{Name: "checkout_time", Type: registry.Histogram, Value: 1500, Dimensions: map[string]string{}},
+			},
+		},
+		{
+			line: `<190>1 2020-01-13T18:27:50+00:00 host app worker.1 - queue default has 12 jobs`,
+			expected: []*registry.MetricVal{
+				{Name: "queue_depth", Type: datapoint.Gauge, Value: 12, Dimensions: map[string]string{"queue": "default"}},
+			},
+		},
+		{
+			line: `<190>1 2020-01-13T18:27:50+00:00 host app worker.2 - queue default has 12 jobs`,
+		},
+	}
+
+	for _, tc := range testCases {
+		ll, err := detectAndParseLog(tc.line)
+		require.NoError(t, err)
+
+		require.Equalf(t, tc.expected, applyLogRules(rules, ll), "Unexpected metrics for %s", tc.line)
+	}
+}
+
+func TestCompileLogRulesErrors(t *testing.T) {
+	invalidRules := []LogRule{
+		{Type: "counter", Pattern: `checkout`},
+		{Metric: "checkouts", Type: "summary", Pattern: `checkout`},
+		{Metric: "checkouts", Type: "counter", Pattern: `checkout (`},
+		{Metric: "checkout_time", Type: "gauge", Pattern: `checkout in (?P<time>\S+)`},
+	}
+
+	for _, rule := range invalidRules {
+		_, err := compileLogRules([]LogRule{rule})
+		require.Errorf(t, err, "Expected rule %v to be invalid", rule)
+	}
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+)
+
+// StatsD and DogStatsD lines, i.e. "jobs.processed:1|c|@0.5|#queue:default".
+// For more information, see:
+// https://github.com/statsd/statsd/blob/master/docs/metric_types.md and
+// https://docs.datadoghq.com/developers/dogstatsd/datagram_shell/
+var statsdFormat = regexp.MustCompile(`^([^:|\s]+):([^|\s]+)\|(c|g|ms|h|d|s)((?:\|[^|\s]*)*)$`)
+
+var statsdMetricTypes = map[string]datapoint.MetricType{
+	"c":  datapoint.Count,
+	"g":  datapoint.Gauge,
+	"ms": registry.Histogram,
+	"h":  registry.Histogram,
+	"d":  registry.Histogram,
+	"s":  registry.Unique,
+}
+
+// Returns the metric in a message that is a StatsD line, and the dimensions
+// from its DogStatsD tags. Returns a nil metric if the message is not a
+// StatsD line.
+func parseStatsD(message string) (*registry.MetricVal, map[string]string, error) {
+	match := statsdFormat.FindStringSubmatch(strings.TrimSpace(message))
+	if match == nil {
+		return nil, nil, nil
+	}
+
+	metric := &registry.MetricVal{
+		Name: match[1],
+		Type: statsdMetricTypes[match[3]],
+	}
+
+	dims := map[string]string{}
+	sampleRate := 1.0
+
+	for _, field := range strings.Split(match[4], "|") {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, nil, fmt.Errorf("invalid sample rate %q", field[1:])
+			}
+
+			sampleRate = rate
+		case strings.HasPrefix(field, "#"):
+			for _, tag := range strings.Split(field[1:], ",") {
+				// Tags without a value can't be dimensions
+				if kv := strings.SplitN(tag, ":", 2); len(kv) == 2 && kv[0] != "" && kv[1] != "" {
+					dims[kv[0]] = kv[1]
+				}
+			}
+		}
+	}
+
+	// The members of sets are not numeric
+	if metric.Type == registry.Unique {
+		metric.Member = match[2]
+		return metric, dims, nil
+	}
+
+	value, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch metric.Type {
+	case datapoint.Count:
+		// Sampled counters only count a fraction of the events
+		value /= sampleRate
+	case registry.Histogram:
+		// Each sampled value stands for 1/rate observations
+		metric.Weight = 1 / sampleRate
+	case datapoint.Gauge:
+		// Signed gauge values are changes to the current value
+		metric.Delta = match[2][0] == '+' || match[2][0] == '-'
+	}
+
+	metric.Value = value
+
+	return metric, dims, nil
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/heroku-signalfx-collector/internal/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsD(t *testing.T) {
+	testCases := []struct {
+		message      string
+		expected     *registry.MetricVal
+		expectedDims map[string]string
+		expectErr    bool
+	}{
+		{
+			message:      "jobs.processed:1|c|#queue:default",
+			expected:     &registry.MetricVal{Name: "jobs.processed", Type: datapoint.Count, Value: 1},
+			expectedDims: map[string]string{"queue": "default"},
+		},
+		{
+			message:      "jobs.processed:1|c|@0.25",
+			expected:     &registry.MetricVal{Name: "jobs.processed", Type: datapoint.Count, Value: 4},
+			expectedDims: map[string]string{},
+		},
+		{
+			message:      "queue.depth:42.5|g|#queue:default,env,region:us",
+			expected:     &registry.MetricVal{Name: "queue.depth", Type: datapoint.Gauge, Value: 42.5},
+			expectedDims: map[string]string{"queue": "default", "region": "us"},
+		},
+		{
+			message:      "job.time:320|ms|@0.5",
+			expected:     &registry.MetricVal{Name: "job.time", Type: registry.Histogram, Value: 320, Weight: 2},
+			expectedDims: map[string]string{},
+		},
+		{
+			message:      "payload.size:2048|h",
+			expected:     &registry.MetricVal{Name: "payload.size", Type: registry.Histogram, Value: 2048, Weight: 1},
+			expectedDims: map[string]string{},
+		},
+		{
+			message:      "queue.depth:+5|g",
+			expected:     &registry.MetricVal{Name: "queue.depth", Type: datapoint.Gauge, Value: 5, Delta: true},
+			expectedDims: map[string]string{},
+		},
+		{
+			message:      "queue.depth:-3|g",
+			expected:     &registry.MetricVal{Name: "queue.depth", Type: datapoint.Gauge, Value: -3, Delta: true},
+			expectedDims: map[string]string{},
+		},
+		{
+			message:      "users.active:user-42|s",
+			expected:     &registry.MetricVal{Name: "users.active", Type: registry.Unique, Member: "user-42"},
+			expectedDims: map[string]string{},
+		},
+		{
+			message: "at=info method=GET path=/",
+		},
+		{
+			message: "Connecting to db:5432",
+		},
+		{
+			message:   "jobs.processed:1|c|@0",
+			expectErr: true,
+		},
+		{
+			message:   "jobs.processed:one|c",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		metric, dims, err := parseStatsD(tc.message)
+
+		if tc.expectErr {
+			require.Errorf(t, err, "Expected error for %s", tc.message)
+			continue
+		}
+
+		require.NoErrorf(t, err, "Unexpected error for %s", tc.message)
+		require.Equalf(t, tc.expected, metric, "Unexpected metric for %s", tc.message)
+		require.Equalf(t, tc.expectedDims, dims, "Unexpected dimensions for %s", tc.message)
+	}
+}
+
+func TestProcessMetricsStatsD(t *testing.T) {
+	ll, err := detectAndParseLog(`<190>1 2020-01-13T18:27:50+00:00 host app worker.1 - jobs.processed:1|c|#queue:default,dyno:other,app_name:other-app`)
+	require.NoError(t, err)
+
+	metrics, dims := processMetrics(ll, map[string]string{"app_name": "test-app"})
+
+	require.Equal(t, []*registry.MetricVal{
+		{Name: "jobs.processed", Type: datapoint.Count, Value: 1},
+	}, metrics)
+
+	// Tags can't override the dimensions of the drain or the dyno
+	require.Equal(t, "default", dims["queue"])
+	require.Equal(t, "worker.1", dims["dyno"])
+	require.Equal(t, "test-app", dims["app_name"])
+}
+
+// Lines that look like StatsD lines but aren't valid ones are parsed as
+// key/value pairs
+func TestProcessMetricsInvalidStatsD(t *testing.T) {
+	ll, err := detectAndParseLog(`<190>1 2020-01-13T18:27:50+00:00 host app worker.1 - sfxdimension#team=a:1|c|@2`)
+	require.NoError(t, err)
+
+	metrics, dims := ll.evaluateKeyValuePairs()
+
+	require.Empty(t, metrics)
+	require.Equal(t, "a:1|c|@2", dims["team"])
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+)
+
+var errInvalidStructuredData = errors.New("invalid structured data")
+
+// SD-IDs without an "@" are reserved for the ones registered with IANA. For
+// more information, see: https://tools.ietf.org/html/rfc5424#section-7
+var registeredSDIDs = makeStringSet("timeQuality", "origin", "meta")
+
+// Parses the STRUCTURED-DATA part of an RFC 5424 message, at the start of s,
+// into a map of SD-ID to parameters. Returns the rest of s after the
+// structured data.
+// For more information, see: https://tools.ietf.org/html/rfc5424#section-6.3
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	sd := map[string]map[string]string{}
+
+	for len(s) > 0 && s[0] == '[' {
+		id, params, rest, err := parseSDElement(s[1:])
+		if err != nil {
+			return nil, s, err
+		}
+
+		sd[id] = params
+		s = rest
+	}
+
+	if len(sd) == 0 {
+		return nil, s, errInvalidStructuredData
+	}
+
+	return sd, s, nil
+}
+
+// Parses an SD-ELEMENT, without the opening bracket
+func parseSDElement(s string) (string, map[string]string, string, error) {
+	id, s := readSDName(s)
+	if id == "" {
+		return "", nil, "", errInvalidStructuredData
+	}
+
+	params := map[string]string{}
+
+	for {
+		if s == "" {
+			return "", nil, "", errInvalidStructuredData
+		}
+
+		if s[0] == ']' {
+			return id, params, s[1:], nil
+		}
+
+		if s[0] != ' ' {
+			return "", nil, "", errInvalidStructuredData
+		}
+
+		var name string
+
+		name, s = readSDName(s[1:])
+		if name == "" || !strings.HasPrefix(s, `="`) {
+			return "", nil, "", errInvalidStructuredData
+		}
+
+		value, rest, ok := readSDParamValue(s[2:])
+		if !ok {
+			return "", nil, "", errInvalidStructuredData
+		}
+
+		params[name] = value
+		s = rest
+	}
+}
+
+// SD-NAME is 1 to 32 printable US-ASCII characters except '=', SP, ']' and '"'
+func readSDName(s string) (string, string) {
+	i := 0
+	for i < len(s) && i < 32 && s[i] > ' ' && s[i] < 127 && s[i] != '=' && s[i] != ']' && s[i] != '"' {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+// Reads a PARAM-VALUE up to the closing quote, handling the escaped
+// characters '"', '\' and ']'
+func readSDParamValue(s string) (string, string, bool) {
+	var value strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return value.String(), s[i+1:], true
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+			}
+		}
+
+		value.WriteByte(s[i])
+	}
+
+	return "", "", false
+}
+
+// Heroku does not include STRUCTURED-DATA in its messages, so a message that
+// starts with '[' is only considered to have structured data if all the
+// SD-IDs are either registered or valid private ones (i.e. "name@32473").
+func splitStructuredData(message string) (map[string]map[string]string, string) {
+	sd, rest, err := parseStructuredData(message)
+	if err != nil {
+		return nil, message
+	}
+
+	for id := range sd {
+		if !isValidSDID(id) {
+			return nil, message
+		}
+	}
+
+	return sd, strings.TrimPrefix(rest, " ")
+}
+
+// Private SD-IDs are a name followed by "@" and an enterprise number, i.e.
+// "exampleSDID@32473" or "origin@32473.1.2"
+func isValidSDID(id string) bool {
+	if registeredSDIDs[id] {
+		return true
+	}
+
+	i := strings.IndexByte(id, '@')
+	if i < 1 {
+		return false
+	}
+
+	for _, part := range strings.Split(id[i+1:], ".") {
+		if !isDigits(part) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the value of a parameter with the given name from any of the
+// structured data elements
+func structuredDataParam(sd map[string]map[string]string, name string) string {
+	for _, params := range sd {
+		if params[name] != "" {
+			return params[name]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStructuredData(t *testing.T) {
+	sd, rest, err := parseStructuredData(`[exampleSDID@32473 iut="3" eventSource="App\"lication\]"][meta app_name="my-app"] message`)
+	require.NoError(t, err)
+	require.Equal(t, " message", rest)
+	require.Equal(t, map[string]map[string]string{
+		"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication]`},
+		"meta":              {"app_name": "my-app"},
+	}, sd)
+
+	for _, invalid := range []string{`[]`, `[id`, `[id key=value]`, `[id key="value]`, `message`} {
+		_, _, err = parseStructuredData(invalid)
+		require.Errorf(t, err, "Expected %q to be invalid", invalid)
+	}
+
+	// Messages starting with brackets are common in app logs
+	sd, rest = splitStructuredData("[INFO] Listening on port 8000")
+	require.Nil(t, sd)
+	require.Equal(t, "[INFO] Listening on port 8000", rest)
+
+	// Private SD-IDs end with an enterprise number
+	sd, rest = splitStructuredData("[user@example.com] logged in")
+	require.Nil(t, sd)
+	require.Equal(t, "[user@example.com] logged in", rest)
+
+	sd, _ = splitStructuredData(`[origin@32473.1.2 ip="10.0.0.1"] message`)
+	require.Equal(t, map[string]map[string]string{"origin@32473.1.2": {"ip": "10.0.0.1"}}, sd)
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Connections that don't send anything for this long are closed
+const syslogIdleTimeout = 10 * time.Minute
+
+// Maximum size of a UDP datagram
+const maxDatagramSize = 64 * 1024
+
+// ServeSyslog accepts syslog over TCP (or TLS, if ln is a TLS listener)
+// connections on ln and processes the RFC 5425/6587 framed messages sent
+// on them the same way as drain requests. Messages are mapped to an app
+// by appName, if set, by their syslog hostname (see Listener.lineDimensions)
+// or by the app_name parameter of their structured data. Ports with an
+// appName are bound to that app, so all of their messages are processed.
+// Otherwise, only messages from allowed drain tokens are processed, if any
+// (see Listener.processSyslogLine). Blocks until the listener is shutdown or
+// ln fails.
+func (l *Listener) ServeSyslog(ln net.Listener, appName string) error {
+	go func() {
+		<-l.ctx.Done()
+		ln.Close()
+	}()
+
+	dims := map[string]string{}
+	processLine := l.processSyslogLine
+	if appName != "" {
+		dims["app_name"] = appName
+		processLine = l.processLine
+	}
+
+	log.WithFields(log.Fields{
+		"address": ln.Addr().String(),
+		"appName": appName,
+	}).Info("Listening for syslog connections")
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return nil
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		atomic.AddInt64(&l.syslogConnections, 1)
+
+		// Close connections past the limit right away instead of keeping
+		// an unbounded number of them open
+		if l.syslogConns != nil {
+			select {
+			case l.syslogConns <- struct{}{}:
+			default:
+				atomic.AddInt64(&l.rejectedSyslogConnections, 1)
+
+				log.WithFields(log.Fields{
+					"remoteAddress": conn.RemoteAddr().String(),
+				}).Warn("Closing syslog connection since there are too many open connections")
+
+				conn.Close()
+
+				continue
+			}
+		}
+
+		go l.handleSyslogConn(conn, dims, processLine)
+	}
+}
+
+func (l *Listener) handleSyslogConn(conn net.Conn, dims map[string]string, processLine func(string, map[string]string) bool) {
+	defer conn.Close()
+
+	if l.syslogConns != nil {
+		defer func() { <-l.syslogConns }()
+	}
+
+	log.WithFields(log.Fields{
+		"remoteAddress": conn.RemoteAddr().String(),
+	}).Debug("Accepted syslog connection")
+
+	frames := newStreamFrameReader(&idleTimeoutReader{conn: conn, timeout: syslogIdleTimeout})
+
+	l.processFrames(frames, dims, processLine)
+}
+
+// Extends the read deadline of a connection before every read
+type idleTimeoutReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+
+	return r.conn.Read(p)
+}
+
+// ServeSyslogUDP processes RFC 5424 and RFC 3164 syslog messages received as
+// UDP datagrams on conn, one message per datagram. Messages are mapped to an
+// app by the address they are sent from (see Config.SyslogUDPSources) or by
+// appName, if set, in addition to the ways TCP messages are mapped. When
+// sources are configured, datagrams from other addresses are dropped and the
+// ones from configured sources skip the drain token check. Blocks until the
+// listener is shutdown or conn fails.
+func (l *Listener) ServeSyslogUDP(conn net.PacketConn, appName string) error {
+	go func() {
+		<-l.ctx.Done()
+		conn.Close()
+	}()
+
+	log.WithFields(log.Fields{
+		"address": conn.LocalAddr().String(),
+		"appName": appName,
+	}).Info("Listening for syslog datagrams")
+
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return nil
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+
+			return err
+		}
+
+		atomic.AddInt64(&l.receivedDatagrams, 1)
+
+		dims := map[string]string{}
+		if appName != "" {
+			dims["app_name"] = appName
+		}
+
+		// UDP has no connection to authenticate, so datagrams have to come
+		// from one of the configured sources, if any. Datagrams from a
+		// configured source are trusted and skip the drain token check.
+		processLine := l.processSyslogLine
+		if len(l.udpSources) > 0 {
+			sourceAppName, ok := l.udpSourceAppName(addr)
+			if !ok {
+				atomic.AddInt64(&l.droppedDatagrams, 1)
+
+				log.WithFields(log.Fields{
+					"remoteAddress": addr.String(),
+				}).Debug("Dropping syslog datagram from an unknown source")
+
+				continue
+			}
+
+			if sourceAppName != "" {
+				dims["app_name"] = sourceAppName
+			}
+
+			processLine = l.processLine
+		}
+
+		line := strings.TrimRight(string(buf[:n]), "\r\n\x00")
+
+		if processLine(line, dims) {
+			atomic.AddInt64(&l.parsedDatagrams, 1)
+		} else {
+			atomic.AddInt64(&l.droppedDatagrams, 1)
+		}
+	}
+}
+
+// Returns the app name of the first configured source that matches the
+// address a datagram was sent from, and false if none does
+func (l *Listener) udpSourceAppName(addr net.Addr) (string, bool) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return "", false
+	}
+
+	for _, source := range l.udpSources {
+		if source.Network.Contains(udpAddr.IP) {
+			return source.AppName, true
+		}
+	}
+
+	return "", false
+}
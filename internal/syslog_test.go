@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeSyslog(t *testing.T) {
+	listener := newTestListener(t, defaultConfig)
+	defer listener.Shutdown()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = listener.ServeSyslog(ln, "port-app")
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+
+	// Octet-counted and newline terminated messages can be mixed
+	_, err = conn.Write([]byte("103 <190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 sfxdimension#service=a" +
+		"<190>1 2019-12-21T22:21:26.705132+00:00 myhost app web.1 - [meta app_name=\"sd-app\"] counter#changed_bytes=5 sfxdimension#service=b\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		return len(listener.registry.Datapoints()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	appNames := map[string]string{}
+	for _, dp := range listener.registry.Datapoints() {
+		appNames[dp.Dimensions["service"]] = dp.Dimensions["app_name"]
+	}
+
+	// The app name of the port can't be overridden by structured data
+	require.Equal(t, map[string]string{"a": "port-app", "b": "port-app"}, appNames)
+}
+
+func TestServeSyslogDrainTokens(t *testing.T) {
+	conf := defaultConfig
+	conf.DrainTokens = getDrainTokens("d.allowed")
+
+	listener := newTestListener(t, conf)
+	defer listener.Shutdown()
+
+	// Ports with an app name are bound to that app, so their lines are
+	// processed whatever their hostname is
+	for _, appName := range []string{"", "port-app"} {
+		port := appName
+		if port == "" {
+			port = "any"
+		}
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		go func(appName string) {
+			_ = listener.ServeSyslog(ln, appName)
+		}(appName)
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("<190>1 2019-12-21T22:21:26.705132+00:00 d.allowed app web.1 - [meta app_name=\"sd-app\"] counter#changed_bytes=5 sfxdimension#port=" + port + " sfxdimension#token=allowed\n" +
+			"<190>1 2019-12-21T22:21:26.705132+00:00 d.unknown app web.1 - [meta app_name=\"other-app\"] counter#changed_bytes=5 sfxdimension#port=" + port + " sfxdimension#token=unknown\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	}
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&listener.unauthorizedLines) == 1 && len(listener.registry.Datapoints()) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	appNames := map[string]string{}
+	for _, dp := range listener.registry.Datapoints() {
+		appNames[dp.Dimensions["port"]+"/"+dp.Dimensions["token"]] = dp.Dimensions["app_name"]
+	}
+
+	require.Equal(t, map[string]string{
+		"any/allowed":      "sd-app",
+		"port-app/allowed": "port-app",
+		"port-app/unknown": "port-app",
+	}, appNames)
+}
+
+func TestServeSyslogUDP(t *testing.T) {
+	conf := defaultConfig
+
+	sources, err := getSyslogSources("127.0.0.1=source-app")
+	require.NoError(t, err)
+
+	conf.SyslogUDPSources = sources
+
+	listener := newTestListener(t, conf)
+	defer listener.Shutdown()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = listener.ServeSyslogUDP(conn, "port-app")
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	for _, datagram := range []string{
+		"<190>1 2019-12-21T22:21:26.705132+00:00 host app web.1 - counter#changed_bytes=5 sfxdimension#service=a\n",
+		"<13>Oct 11 22:14:15 myhost worker[1234]: counter#changed_bytes=5 sfxdimension#service=b",
+		"not syslog",
+	} {
+		_, err = client.Write([]byte(datagram))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&listener.parsedDatagrams)+atomic.LoadInt64(&listener.droppedDatagrams) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, int64(2), atomic.LoadInt64(&listener.parsedDatagrams))
+	require.Equal(t, int64(1), atomic.LoadInt64(&listener.droppedDatagrams))
+
+	for _, dp := range listener.registry.Datapoints() {
+		require.Equal(t, "source-app", dp.Dimensions["app_name"])
+	}
+}
+
+func TestServeSyslogConnectionLimit(t *testing.T) {
+	conf := defaultConfig
+	conf.MaxConcurrentRequests = 1
+
+	listener := newTestListener(t, conf)
+	defer listener.Shutdown()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = listener.ServeSyslog(ln, "port-app")
+	}()
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+
+	defer first.Close()
+
+	require.Eventually(t, func() bool {
+		return len(listener.syslogConns) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Connections past the limit are closed by the collector
+	second, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+
+	defer second.Close()
+
+	require.NoError(t, second.SetReadDeadline(time.Now().Add(time.Second)))
+
+	_, err = second.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, int64(1), atomic.LoadInt64(&listener.rejectedSyslogConnections))
+
+	// Slots are released once connections are closed
+	require.NoError(t, first.Close())
+
+	require.Eventually(t, func() bool {
+		return len(listener.syslogConns) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServeSyslogUDPUnknownSource(t *testing.T) {
+	conf := defaultConfig
+
+	sources, err := getSyslogSources("10.0.0.0/8=source-app")
+	require.NoError(t, err)
+
+	conf.SyslogUDPSources = sources
+
+	listener := newTestListener(t, conf)
+	defer listener.Shutdown()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = listener.ServeSyslogUDP(conn, "port-app")
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	_, err = client.Write([]byte("<190>1 2019-12-21T22:21:26.705132+00:00 myhost app web.1 - [meta app_name=\"sd-app\"] counter#changed_bytes=5"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&listener.droppedDatagrams) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, int64(0), atomic.LoadInt64(&listener.parsedDatagrams))
+	require.Empty(t, listener.registry.Datapoints())
+}
+
+// Datagrams from a configured source are processed whatever their hostname is
+func TestServeSyslogUDPSourcesAndDrainTokens(t *testing.T) {
+	conf := defaultConfig
+	conf.DrainTokens = getDrainTokens("d.allowed")
+
+	sources, err := getSyslogSources("127.0.0.1=source-app")
+	require.NoError(t, err)
+
+	conf.SyslogUDPSources = sources
+
+	listener := newTestListener(t, conf)
+	defer listener.Shutdown()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = listener.ServeSyslogUDP(conn, "")
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	_, err = client.Write([]byte("<190>1 2019-12-21T22:21:26.705132+00:00 d.unknown app web.1 - counter#changed_bytes=5"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&listener.parsedDatagrams) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, int64(0), atomic.LoadInt64(&listener.unauthorizedLines))
+
+	dps := listener.registry.Datapoints()
+	require.Len(t, dps, 1)
+	require.Equal(t, "source-app", dps[0].Dimensions["app_name"])
+}
@@ -3,12 +3,15 @@ package internal
 import (
 	"strconv"
 	"strings"
+
+	"github.com/docker/go-units"
 )
 
 // Returns true if a key/value pair represents a metricVal. Inputs to this method
 // are always expected to be of the form "key=value"
 func isMetric(key string, metricsToIncludeFromMessage map[string]bool) bool {
-	return metricsToIncludeFromMessage[key] || isGauge(key) || isCumulative(key) || isCounter(key) || isSample(key)
+	return metricsToIncludeFromMessage[key] || isGauge(key) || isCumulative(key) || isCounter(key) || isSample(key) || isHistogram(key) ||
+		isMeasure(key) || isCount(key) || isUnique(key)
 }
 
 // Returns true if a key represents a dimension key/value pair needs to be synced
@@ -32,6 +35,24 @@ func isSample(key string) bool {
 	return strings.HasPrefix(key, "sample#")
 }
 
+func isHistogram(key string) bool {
+	return strings.HasPrefix(key, "histogram#")
+}
+
+// The measure#, count# and unique# prefixes are the ones of l2met. For more
+// information, see: https://github.com/ryandotsmith/l2met/wiki/Usage
+func isMeasure(key string) bool {
+	return strings.HasPrefix(key, "measure#")
+}
+
+func isCount(key string) bool {
+	return strings.HasPrefix(key, "count#")
+}
+
+func isUnique(key string) bool {
+	return strings.HasPrefix(key, "unique#")
+}
+
 func mergeStringMaps(maps ...map[string]string) map[string]string {
 	ret := map[string]string{}
 
@@ -56,6 +77,19 @@ func mergeBoolMaps(maps ...map[string]bool) map[string]bool {
 	return ret
 }
 
+// Splits a comma separated list, ignoring whitespace around and empty items
+func splitList(list string) []string {
+	var out []string
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+
+	return out
+}
+
 func makeSetOfStringsFromArray(metricsToExlcude []string) map[string]bool {
 	ret := map[string]bool{}
 
@@ -74,6 +108,19 @@ func evaluateBoolEnvVariable(envVal string, defaultVal bool) (bool, error) {
 	return strconv.ParseBool(envVal)
 }
 
+func evaluateIntEnvVariable(envVal string, defaultVal int) (int, error) {
+	if envVal == "" {
+		return defaultVal, nil
+	}
+
+	n, err := strconv.ParseInt(envVal, 10, 32)
+	if err != nil {
+		return defaultVal, err
+	}
+
+	return int(n), nil
+}
+
 func makeStringSet(vals ...string) map[string]bool {
 	out := make(map[string]bool)
 	for _, v := range vals {
@@ -82,3 +129,17 @@ func makeStringSet(vals ...string) map[string]bool {
 
 	return out
 }
+
+// Sizes can have units, i.e. "5MB"
+func evaluateSizeEnvVariable(envVal string, defaultVal int64) (int64, error) {
+	if envVal == "" {
+		return defaultVal, nil
+	}
+
+	n, err := units.RAMInBytes(envVal)
+	if err != nil {
+		return defaultVal, err
+	}
+
+	return n, nil
+}
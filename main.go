@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -10,11 +12,15 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/event"
 	"github.com/signalfx/golib/v3/sfxclient"
 	"github.com/signalfx/heroku-signalfx-collector/internal"
 	sfxwriter "github.com/signalfx/signalfx-go/writer"
 )
 
+// Maximum number of events waiting to be sent, past which events are dropped
+const eventQueueSize = 100
+
 func main() {
 	conf := internal.ConfigFromEnv()
 	if err := conf.Validate(); err != nil {
@@ -45,7 +51,11 @@ func main() {
 
 	datapointWriter.Start(context.Background())
 
-	listener, err := internal.NewListener(conf.IntervalSeconds, dpChan)
+	eventChan := make(chan *event.Event, eventQueueSize)
+
+	go sendEvents(client, eventChan)
+
+	listener, err := internal.NewListener(conf, dpChan, eventChan)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -58,6 +68,11 @@ func main() {
 		os.Exit(3)
 	}
 
+	if err := startSyslogListeners(conf, listener); err != nil {
+		log.WithError(err).Error("Failed to start syslog listeners")
+		os.Exit(5)
+	}
+
 	http.HandleFunc("/", listener.ProcessLogs)
 
 	if conf.SendInternalMetrics {
@@ -77,6 +92,68 @@ func main() {
 	log.Infoln("Shutting Down")
 }
 
+func startSyslogListeners(conf *internal.Config, listener *internal.Listener) error {
+	var listeners []net.Listener
+
+	var appNames []string
+
+	for _, p := range conf.SyslogTCPPorts {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", p.Port))
+		if err != nil {
+			return err
+		}
+
+		listeners = append(listeners, ln)
+		appNames = append(appNames, p.AppName)
+	}
+
+	if len(conf.SyslogTLSPorts) > 0 {
+		tlsConfig, err := conf.SyslogTLSConfig()
+		if err != nil {
+			return err
+		}
+
+		for _, p := range conf.SyslogTLSPorts {
+			ln, err := tls.Listen("tcp", fmt.Sprintf(":%d", p.Port), tlsConfig)
+			if err != nil {
+				return err
+			}
+
+			listeners = append(listeners, ln)
+			appNames = append(appNames, p.AppName)
+		}
+	}
+
+	for _, p := range conf.SyslogUDPPorts {
+		conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", p.Port))
+		if err != nil {
+			return err
+		}
+
+		go func(conn net.PacketConn, appName string) {
+			if err := listener.ServeSyslogUDP(conn, appName); err != nil {
+				log.WithFields(log.Fields{
+					"error":   err,
+					"address": conn.LocalAddr().String(),
+				}).Error("Syslog listener stopped")
+			}
+		}(conn, p.AppName)
+	}
+
+	for i := range listeners {
+		go func(ln net.Listener, appName string) {
+			if err := listener.ServeSyslog(ln, appName); err != nil {
+				log.WithFields(log.Fields{
+					"error":   err,
+					"address": ln.Addr().String(),
+				}).Error("Syslog listener stopped")
+			}
+		}(listeners[i], appNames[i])
+	}
+
+	return nil
+}
+
 func setupLogging(conf *internal.Config) {
 	// Output to stderr instead of stdout
 	log.SetOutput(os.Stderr)
@@ -103,11 +180,43 @@ func makeClient(conf *internal.Config) *sfxclient.HTTPSink {
 		panic("ingest URL or realm should be set")
 	}
 
+	switch {
+	case conf.IngestURL != "":
+		client.EventEndpoint = fmt.Sprintf("%s/v2/event", conf.IngestURL)
+	case conf.Realm != "":
+		client.EventEndpoint = fmt.Sprintf("https://ingest.%s.signalfx.com/v2/event", conf.Realm)
+	}
+
 	log.Infof("Sending datapoints to %s", client.DatapointEndpoint)
+	log.Infof("Sending events to %s", client.EventEndpoint)
 
 	return client
 }
 
+// Sends events as they are queued, along with any other event queued in
+// the meantime
+func sendEvents(client *sfxclient.HTTPSink, eventChan <-chan *event.Event) {
+	for e := range eventChan {
+		events := []*event.Event{e}
+
+	batch:
+		for len(events) < eventQueueSize {
+			select {
+			case e := <-eventChan:
+				events = append(events, e)
+			default:
+				break batch
+			}
+		}
+
+		if err := client.AddEvents(context.Background(), events); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to dispatch events to SignalFx")
+		}
+	}
+}
+
 func sendInternalMetrics(intervalSeconds int, dpChan chan<- []*datapoint.Datapoint, listener *internal.Listener) {
 	ticker := time.NewTicker(time.Duration(intervalSeconds) * time.Second)
 	defer ticker.Stop()